@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type generator struct {
+	g     *grammar
+	b     bytes.Buffer
+	funcs int // number of functions emitted for the current rule
+}
+
+func (gen *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&gen.b, format, args...)
+}
+
+// generate emits the Go source of a lexer for g.
+func generate(g *grammar, pkg, origin string) ([]byte, error) {
+	gen := &generator{g: g}
+	kinds := make([]string, len(g.rules))
+	seen := map[string]string{}
+	for i, r := range g.rules {
+		kinds[i] = kind_name(r.name)
+		if other, ok := seen[kinds[i]]; ok {
+			return nil, fmt.Errorf("rules %q and %q both map to %s", other, r.name, kinds[i])
+		}
+		seen[kinds[i]] = r.name
+	}
+
+	gen.printf("// Code generated by parsegen from %s; DO NOT EDIT.\n\n", origin)
+	gen.printf("package %s\n\n", pkg)
+	gen.printf("import (\n\t\"fmt\"\n\t\"strconv\"\n\t\"unicode/utf8\"\n)\n\n")
+
+	gen.printf("// Kind identifies the grammar rule that matched a token.\ntype Kind int\n\nconst (\n")
+	for i, k := range kinds {
+		if i == 0 {
+			gen.printf("\t%s = Kind(iota)\n", k)
+		} else {
+			gen.printf("\t%s\n", k)
+		}
+	}
+	gen.printf(")\n\n")
+
+	gen.printf("var parsegen_kind_names = [...]string{\n")
+	for _, r := range g.rules {
+		gen.printf("\t%q,\n", r.name)
+	}
+	gen.printf("}\n\nvar parsegen_kind_descriptions = [...]string{\n")
+	for _, r := range g.rules {
+		gen.printf("\t%q,\n", r.descr)
+	}
+	gen.printf("}\n\nvar parsegen_rules = [...]func(*parsegen_lexer) parsegen_code{\n")
+	for _, r := range g.rules {
+		gen.printf("\t(*parsegen_lexer).rule_%s,\n", r.name)
+	}
+	gen.printf("}\n\n")
+
+	gen.b.WriteString(runtime)
+	gen.tokenize()
+	gen.next(kinds)
+	for _, r := range g.rules {
+		gen.funcs = 0
+		gen.term(r, fmt.Sprintf("rule_%s", r.name), r.e)
+	}
+
+	src, err := format.Source(gen.b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w", err)
+	}
+	return src, nil
+}
+
+// kind_name converts a rule name to the exported name of its Kind constant,
+// e.g. line_comment becomes KindLineComment.
+func kind_name(name string) string {
+	s := "Kind"
+	for _, part := range strings.Split(name, "_") {
+		if part != "" {
+			s += strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return s
+}
+
+// runtime is the part of the generated lexer that does not depend on the
+// grammar. It mirrors the decoding of parse.Tokenize with the default
+// options: invalid UTF-8 decodes as U+FFFD, LF is the only line break, and
+// columns count codepoints.
+const runtime = `func (k Kind) String() string {
+	if k >= 0 && int(k) < len(parsegen_kind_names) {
+		return parsegen_kind_names[k]
+	}
+	return "Kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Error reports a failure at a 0-based line and column.
+type Error struct {
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("[%d:%d] %s", e.Line+1, e.Col+1, e.Msg)
+}
+
+type parsegen_code int
+
+const (
+	parsegen_none         = parsegen_code(0)
+	parsegen_unmatched    = parsegen_code(-1)
+	parsegen_unterminated = parsegen_code(3)
+)
+
+type parsegen_lexer struct {
+	buf   []byte
+	pos   int
+	line  int
+	col   int
+	text  []byte
+	muted int
+
+	// expectations, collected only when nothing matches
+	track   bool
+	at      int
+	at_line int
+	at_col  int
+	items   []string
+}
+
+// peek decodes the codepoint at the current position. An invalid sequence
+// decodes as U+FFFD along with the continuation bytes that follow it.
+func (l *parsegen_lexer) peek() (rune, int) {
+	if l.pos >= len(l.buf) {
+		return -1, 0
+	}
+	if c := l.buf[l.pos]; c < utf8.RuneSelf {
+		return rune(c), 1
+	}
+	c, n := utf8.DecodeRune(l.buf[l.pos:])
+	if n < 2 {
+		for l.pos+n < len(l.buf) && l.buf[l.pos+n]&0xc0 == 0x80 {
+			n++
+		}
+	}
+	return c, n
+}
+
+// advance consumes n bytes decoded as c and captures c unless muted.
+func (l *parsegen_lexer) advance(c rune, n int) {
+	if c == '\n' {
+		l.line++
+		l.col = 0
+	} else {
+		l.col++
+	}
+	l.pos += n
+	if l.muted == 0 {
+		l.text = utf8.AppendRune(l.text, c)
+	}
+}
+
+// leap consumes s if the input continues with it.
+func (l *parsegen_lexer) leap(s string) bool {
+	if len(l.buf)-l.pos < len(s) || string(l.buf[l.pos:l.pos+len(s)]) != s {
+		return false
+	}
+	for _, c := range s {
+		l.advance(c, utf8.RuneLen(c))
+	}
+	return true
+}
+
+// expect records a term that did not match at the current position, only
+// the ones at the furthest position are kept.
+func (l *parsegen_lexer) expect(what string) {
+	if l.track {
+		l.add(l.pos, l.line, l.col, what)
+	}
+}
+
+func (l *parsegen_lexer) add(pos, line, col int, what string) {
+	if pos < l.at {
+		return
+	}
+	if pos > l.at {
+		l.at, l.at_line, l.at_col = pos, line, col
+		l.items = l.items[:0]
+	}
+	l.items = append(l.items, what)
+}
+
+// expected replays the rules at a position where none of them matched and
+// reports what was expected at the furthest position reached.
+func (l *parsegen_lexer) expected(start, line, col int) error {
+	l.pos, l.line, l.col = start, line, col
+	l.track, l.at, l.items = true, -1, l.items[:0]
+	for i, rule := range parsegen_rules {
+		l.text = l.text[:0]
+		n := 0
+		if l.at == start {
+			n = len(l.items)
+		}
+		if rule(l) == parsegen_unmatched {
+			// the description replaces whatever the rule expected at
+			// its starting position
+			if l.at == start {
+				l.items = l.items[:n]
+			}
+			l.add(start, line, col, parsegen_kind_descriptions[i])
+		}
+	}
+	items := []string{}
+	seen := map[string]bool{}
+	for _, s := range l.items {
+		if !seen[s] {
+			seen[s] = true
+			items = append(items, s)
+		}
+	}
+	msg := "unexpected content"
+	switch n := len(items); n {
+	case 0:
+		return &Error{Line: line, Col: col, Msg: msg}
+	case 1:
+		msg = "expected " + items[0]
+	default:
+		msg = "expected " + items[0]
+		for _, s := range items[1 : n-1] {
+			msg += ", " + s
+		}
+		msg += " or " + items[n-1]
+	}
+	return &Error{Line: l.at_line, Col: l.at_col, Msg: msg}
+}
+
+// next_from tries the rules in order starting at i. It takes over from the
+// dispatch in next after a rule consumed content and still reported
+// unmatched, as the following rules start from the new position then.
+func (l *parsegen_lexer) next_from(i int) (Kind, parsegen_code) {
+	for ; i < len(parsegen_rules); i++ {
+		l.text = l.text[:0]
+		if c := parsegen_rules[i](l); c != parsegen_unmatched {
+			return Kind(i), c
+		}
+	}
+	return 0, parsegen_unmatched
+}
+
+`
+
+func (gen *generator) tokenize() {
+	gen.printf(`// Tokenize splits buf into tokens and calls on_token for each of them with
+// the kind of the matching rule, the captured text, and the 0-based line and
+// column of the token start. Rules are tried in the grammar order and the
+// first one that matches wins, skipped content is not reported.
+func Tokenize(buf []byte, on_token func(kind Kind, text string, line, col int)) error {
+	l := parsegen_lexer{buf: buf}
+	for l.pos < len(l.buf) {
+		start, line, col := l.pos, l.line, l.col
+		kind, c := l.next()
+		switch c {
+		case parsegen_none:
+			on_token(kind, string(l.text), line, col)
+		case parsegen_unmatched:
+			return l.expected(start, line, col)
+		default:
+			return &Error{Line: line, Col: col, Msg: "unterminated " + parsegen_kind_descriptions[kind]}
+		}
+	}
+	return nil
+}
+
+`)
+}
+
+// next emits the dispatch on the first byte of a token. Each case lists the
+// rules, in order, that can start with the bytes of the case.
+func (gen *generator) next(kinds []string) {
+	firsts := make([]*[256]bool, len(gen.g.rules))
+	for i, r := range gen.g.rules {
+		firsts[i] = first_bytes(r.e)
+	}
+	groups := map[string][]int{}
+	keys := []string{}
+	for b := 0; b < 256; b++ {
+		key := ""
+		for i, f := range firsts {
+			if f == nil || f[b] {
+				key += strconv.Itoa(i) + ","
+			}
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], b)
+	}
+	// the largest group becomes the default case
+	def := keys[0]
+	for _, k := range keys {
+		if len(groups[k]) > len(groups[def]) {
+			def = k
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return groups[keys[i]][0] < groups[keys[j]][0] })
+
+	gen.printf("// next matches the rules at the current position, selected by the first\n")
+	gen.printf("// byte. It returns the kind of the first rule that does not report unmatched.\n")
+	gen.printf("func (l *parsegen_lexer) next() (Kind, parsegen_code) {\n")
+	for _, r := range gen.g.rules[:len(kinds)-1] {
+		if leaks(r.e) {
+			gen.printf("\tstart := l.pos\n")
+			break
+		}
+	}
+	gen.printf("\tswitch l.buf[l.pos] {\n")
+	emit := func(key string) {
+		for _, s := range strings.Split(strings.TrimSuffix(key, ","), ",") {
+			if s == "" {
+				continue
+			}
+			i, _ := strconv.Atoi(s)
+			gen.printf("\t\tl.text = l.text[:0]\n")
+			gen.printf("\t\tif c := l.rule_%s(); c != parsegen_unmatched {\n\t\t\treturn %s, c\n\t\t}\n", gen.g.rules[i].name, kinds[i])
+			if leaks(gen.g.rules[i].e) && i+1 < len(kinds) {
+				gen.printf("\t\tif l.pos != start {\n\t\t\treturn l.next_from(%d)\n\t\t}\n", i+1)
+			}
+		}
+	}
+	for _, k := range keys {
+		if k == def || k == "" {
+			continue
+		}
+		gen.printf("\tcase ")
+		for n, b := range groups[k] {
+			if n > 0 {
+				gen.printf(",")
+				if n%12 == 0 {
+					gen.printf("\n\t\t")
+				} else {
+					gen.printf(" ")
+				}
+			}
+			gen.printf("%s", byte_literal(byte(b)))
+		}
+		gen.printf(":\n")
+		emit(k)
+	}
+	if def != "" {
+		gen.printf("\tdefault:\n")
+		emit(def)
+	}
+	gen.printf("\t}\n\treturn 0, parsegen_unmatched\n}\n\n")
+}
+
+// leaks reports whether e can report unmatched after consuming content.
+// This happens when the content of a Between does not match.
+func leaks(e *expr) bool {
+	switch e.kind {
+	case expr_between:
+		if len(e.kids) == 3 && (!nullable(e.kids[2]) || leaks(e.kids[2])) {
+			return true
+		}
+		return leaks(e.kids[0])
+	case expr_sequence, expr_one_or_more, expr_skip:
+		return leaks(e.kids[0])
+	case expr_first_of:
+		for _, k := range e.kids {
+			if leaks(k) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func byte_literal(b byte) string {
+	if b >= ' ' && b < utf8.RuneSelf && b != '\\' && b != '\'' {
+		return "'" + string(rune(b)) + "'"
+	}
+	return fmt.Sprintf("0x%02x", b)
+}
+
+// first_bytes returns the set of bytes that can start content matched by e,
+// or nil if e can succeed without consuming anything. When the input does
+// not start with one of these bytes, e reports unmatched without consuming
+// anything.
+func first_bytes(e *expr) *[256]bool {
+	set := &[256]bool{}
+	add_rune := func(c rune) {
+		if c < utf8.RuneSelf {
+			set[c] = true
+		} else {
+			// any non-ASCII codepoint, including U+FFFD for the
+			// invalid sequences
+			for b := 0x80; b < 0x100; b++ {
+				set[b] = true
+			}
+		}
+	}
+	switch e.kind {
+	case expr_literal:
+		c, _ := utf8.DecodeRuneInString(e.text)
+		add_rune(c)
+	case expr_class:
+		for c := rune(0); c < utf8.RuneSelf; c++ {
+			if e.has(c) {
+				set[c] = true
+			}
+		}
+		if e.negated {
+			add_rune(utf8.RuneError)
+		}
+		for _, r := range e.ranges {
+			if r.hi >= utf8.RuneSelf {
+				add_rune(r.hi)
+			}
+		}
+	case expr_sequence, expr_one_or_more, expr_skip, expr_between:
+		return first_bytes(e.kids[0])
+	case expr_first_of:
+		for _, k := range e.kids {
+			f := first_bytes(k)
+			if f == nil {
+				return nil
+			}
+			for b := range f {
+				set[b] = set[b] || f[b]
+			}
+		}
+	default:
+		return nil
+	}
+	return set
+}
+
+// term emits the function fn that matches e, mirroring the semantics of the
+// corresponding parse combinator, including the expectations it records.
+func (gen *generator) term(r *rule, fn string, e *expr) {
+	kids := make([]string, len(e.kids))
+	for i := range e.kids {
+		gen.funcs++
+		kids[i] = fmt.Sprintf("rule_%s_%d", r.name, gen.funcs)
+	}
+
+	gen.printf("func (l *parsegen_lexer) %s() parsegen_code {\n", fn)
+	switch e.kind {
+	case expr_literal:
+		c, size := utf8.DecodeRuneInString(e.text)
+		switch {
+		case size == len(e.text) && c < utf8.RuneSelf:
+			gen.printf("\tif l.pos < len(l.buf) && l.buf[l.pos] == %s {\n", strconv.QuoteRune(c))
+			gen.printf("\t\tl.advance(%s, 1)\n\t\treturn parsegen_none\n\t}\n", strconv.QuoteRune(c))
+		case size == len(e.text):
+			gen.printf("\tif c, n := l.peek(); c == %s {\n", strconv.QuoteRune(c))
+			gen.printf("\t\tl.advance(c, n)\n\t\treturn parsegen_none\n\t}\n")
+		default:
+			gen.printf("\tif l.leap(%q) {\n\t\treturn parsegen_none\n\t}\n", e.text)
+		}
+		gen.printf("\tl.expect(%q)\n\treturn parsegen_unmatched\n", quote_term(e.text))
+	case expr_class:
+		gen.printf("\tc, n := l.peek()\n\tif n == 0 {\n\t\treturn parsegen_unmatched\n\t}\n")
+		gen.printf("\tswitch {\n\tcase %s:\n", class_cond(e))
+		if e.negated {
+			gen.printf("\t\treturn parsegen_unmatched\n\t}\n\tl.advance(c, n)\n\treturn parsegen_none\n")
+		} else {
+			gen.printf("\t\tl.advance(c, n)\n\t\treturn parsegen_none\n\t}\n\treturn parsegen_unmatched\n")
+		}
+	case expr_any:
+		gen.printf("\tc, n := l.peek()\n\tif n == 0 {\n\t\treturn parsegen_unmatched\n\t}\n")
+		gen.printf("\tl.advance(c, n)\n\treturn parsegen_none\n")
+	case expr_sequence:
+		// the result of the first term only decides whether the
+		// sequence matched
+		gen.printf("\tif l.%s() == parsegen_unmatched {\n\t\treturn parsegen_unmatched\n\t}\n", kids[0])
+		for _, k := range kids[1:] {
+			gen.printf("\tif c := l.%s(); c == parsegen_unmatched {\n\t\treturn parsegen_none\n\t} else if c != parsegen_none {\n\t\treturn c\n\t}\n", k)
+		}
+		gen.printf("\treturn parsegen_none\n")
+	case expr_first_of:
+		for _, k := range kids {
+			gen.printf("\tif c := l.%s(); c != parsegen_unmatched {\n\t\treturn c\n\t}\n", k)
+		}
+		gen.printf("\treturn parsegen_unmatched\n")
+	case expr_one_or_more:
+		gen.printf("\tif c := l.%s(); c != parsegen_none {\n\t\treturn c\n\t}\n", kids[0])
+		fallthrough
+	case expr_zero_or_more:
+		gen.printf("\tfor {\n\t\tif c := l.%s(); c == parsegen_unmatched {\n\t\t\treturn parsegen_none\n\t\t} else if c != parsegen_none {\n\t\t\treturn c\n\t\t}\n\t}\n", kids[0])
+	case expr_optional:
+		gen.printf("\tif c := l.%s(); c != parsegen_unmatched {\n\t\treturn c\n\t}\n\treturn parsegen_none\n", kids[0])
+	case expr_skip:
+		gen.printf("\tl.muted++\n\tc := l.%s()\n\tl.muted--\n\treturn c\n", kids[0])
+	case expr_between:
+		gen.printf("\tl.muted++\n\tc := l.%s()\n\tl.muted--\n\tif c != parsegen_none {\n\t\treturn c\n\t}\n", kids[0])
+		if len(kids) == 2 {
+			gen.printf("\tfor {\n\t\tl.muted++\n\t\tc = l.%s()\n\t\tl.muted--\n", kids[1])
+			gen.printf("\t\tif c == parsegen_none {\n\t\t\treturn parsegen_none\n\t\t}\n")
+			gen.printf("\t\tr, n := l.peek()\n\t\tif n == 0 {\n\t\t\treturn parsegen_unterminated\n\t\t}\n\t\tl.advance(r, n)\n\t}\n")
+		} else {
+			gen.printf("\tif c = l.%s(); c == parsegen_none {\n", kids[2])
+			gen.printf("\t\tl.muted++\n\t\tc = l.%s()\n\t\tl.muted--\n", kids[1])
+			gen.printf("\t\tif c == parsegen_unmatched {\n\t\t\tc = parsegen_unterminated\n\t\t}\n\t}\n\treturn c\n")
+		}
+	case expr_eol:
+		gen.printf("\tswitch {\n\tcase l.pos >= len(l.buf):\n\t\treturn parsegen_none\n")
+		gen.printf("\tcase l.buf[l.pos] == '\\n':\n\t\tl.advance('\\n', 1)\n\t\treturn parsegen_none\n")
+		gen.printf("\tcase l.leap(\"\\r\\n\"):\n\t\treturn parsegen_none\n\t}\n")
+		gen.printf("\tl.expect(\"end of line\")\n\treturn parsegen_unmatched\n")
+	case expr_eof:
+		gen.printf("\tif l.pos >= len(l.buf) {\n\t\treturn parsegen_none\n\t}\n")
+		gen.printf("\tl.expect(\"end of input\")\n\treturn parsegen_unmatched\n")
+	}
+	gen.printf("}\n\n")
+
+	for i, k := range e.kids {
+		gen.term(r, kids[i], k)
+	}
+}
+
+// class_cond renders the ranges of a character class as the expression list
+// of a switch case.
+func class_cond(e *expr) string {
+	conds := make([]string, len(e.ranges))
+	for i, r := range e.ranges {
+		if r.lo == r.hi {
+			conds[i] = "c == " + strconv.QuoteRune(r.lo)
+		} else {
+			conds[i] = strconv.QuoteRune(r.lo) + " <= c && c <= " + strconv.QuoteRune(r.hi)
+		}
+	}
+	return strings.Join(conds, ", ")
+}
+
+// quote_term formats literal content for expectation messages, the same
+// way as the parse package does.
+func quote_term(s string) string {
+	q := strconv.Quote(s)
+	return "'" + q[1:len(q)-1] + "'"
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/adnsv/go-parse/parse"
+)
+
+type grammar struct {
+	pkg   string
+	rules []*rule
+}
+
+type rule struct {
+	name  string
+	descr string
+	e     *expr
+}
+
+type expr_kind int
+
+const (
+	expr_literal = expr_kind(iota)
+	expr_class
+	expr_any
+	expr_sequence
+	expr_first_of
+	expr_zero_or_more
+	expr_one_or_more
+	expr_optional
+	expr_skip
+	expr_between
+	expr_eol
+	expr_eof
+)
+
+type expr struct {
+	kind    expr_kind
+	text    string       // expr_literal
+	ranges  []rune_range // expr_class
+	negated bool         // expr_class
+	kids    []*expr
+}
+
+type rune_range struct {
+	lo, hi rune
+}
+
+func (e *expr) has(c rune) bool {
+	for _, r := range e.ranges {
+		if r.lo <= c && c <= r.hi {
+			return !e.negated
+		}
+	}
+	return e.negated
+}
+
+// token is a lexical element of the grammar text.
+type token struct {
+	kind string
+	text string
+	lc   parse.LineCol
+}
+
+func is_space(c rune) bool       { return c == ' ' || c == '\t' || c == '\r' || c == '\n' }
+func is_ident_start(c rune) bool { return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' }
+func is_ident_cont(c rune) bool  { return is_ident_start(c) || '0' <= c && c <= '9' }
+
+func quoted_content(q rune) any {
+	return parse.ZeroOrMore(parse.FirstOf(
+		parse.Sequence('\\', func(c rune) bool { return c != '\n' }),
+		func(c rune) bool { return c != q && c != '\\' && c != '\n' },
+	))
+}
+
+var grammar_bindings = []*parse.Binding[string]{
+	parse.Bind("ws", "whitespace", parse.Skip(parse.OneOrMore(is_space))),
+	parse.Bind("comment", "comment", parse.Skip('#', parse.ZeroOrMore(func(c rune) bool { return c != '\n' }))),
+	parse.Bind("ident", "identifier", is_ident_start, parse.ZeroOrMore(is_ident_cont)),
+	parse.Bind("arrow", "'<-'", "<-"),
+	parse.Bind("string", "string", parse.Between('"', '"', quoted_content('"'))),
+	parse.Bind("string", "string", parse.Between('\'', '\'', quoted_content('\''))),
+	parse.Bind("class", "character class", parse.Between('[', ']', quoted_content(']'))),
+	parse.Bind("punct", "punctuation", parse.AnyOf("(", ")", "/", "*", "+", "?", ",", ".")),
+}
+
+// parse_grammar reads grammar text of the form:
+//
+//	package lexer
+//	name "description" <- expression
+func parse_grammar(src []byte) (*grammar, error) {
+	p := &parser{}
+	err := parse.Tokenize(src, grammar_bindings, func(k string, c *parse.Context, lc parse.LineCol) {
+		if k != "ws" && k != "comment" {
+			p.tokens = append(p.tokens, token{kind: k, text: c.String(), lc: lc})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	last := bytes.LastIndexByte(src, '\n')
+	p.end = parse.LineCol{
+		LineIndex:   bytes.Count(src, []byte{'\n'}),
+		ColumnIndex: utf8.RuneCount(src[last+1:]),
+	}
+	return p.grammar()
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	end    parse.LineCol
+}
+
+func (p *parser) peek(ahead int) *token {
+	if p.pos+ahead < len(p.tokens) {
+		return &p.tokens[p.pos+ahead]
+	}
+	return &token{kind: "eof", lc: p.end}
+}
+
+func (p *parser) next() *token {
+	t := p.peek(0)
+	p.pos++
+	return t
+}
+
+func (p *parser) fail(t *token, format string, args ...any) error {
+	return &parse.ErrAtLineCol{
+		Err: &parse.ErrContent{Code: parse.ErrCodeExpected, What: fmt.Sprintf(format, args...)},
+		Loc: t.lc,
+	}
+}
+
+// accept consumes the next token if it is the punctuation s.
+func (p *parser) accept(s string) bool {
+	if t := p.peek(0); t.kind == "punct" && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// at_rule reports whether the next tokens start a new rule.
+func (p *parser) at_rule() bool {
+	if p.peek(0).kind != "ident" {
+		return false
+	}
+	if p.peek(1).kind == "string" {
+		return p.peek(2).kind == "arrow"
+	}
+	return p.peek(1).kind == "arrow"
+}
+
+func (p *parser) grammar() (*grammar, error) {
+	g := &grammar{}
+	if t := p.peek(0); t.kind == "ident" && t.text == "package" {
+		p.pos++
+		if t = p.next(); t.kind != "ident" {
+			return nil, p.fail(t, "package name")
+		}
+		g.pkg = t.text
+	}
+	seen := map[string]bool{}
+	for p.peek(0).kind != "eof" {
+		start := p.peek(0)
+		if !p.at_rule() {
+			return nil, p.fail(start, "rule")
+		}
+		r := &rule{name: p.next().text}
+		if seen[r.name] {
+			return nil, p.fail(start, "unique rule name instead of %q", r.name)
+		}
+		seen[r.name] = true
+		r.descr = r.name
+		if p.peek(0).kind == "string" {
+			s, err := unquote(p.next().text)
+			if err != nil {
+				return nil, p.fail(start, "valid description")
+			}
+			r.descr = s
+		}
+		p.pos++ // arrow
+		e, err := p.alternatives()
+		if err != nil {
+			return nil, err
+		}
+		if nullable(e) {
+			return nil, p.fail(start, "rule %q that does not match empty content", r.name)
+		}
+		r.e = e
+		g.rules = append(g.rules, r)
+	}
+	if len(g.rules) == 0 {
+		return nil, p.fail(p.peek(0), "rule")
+	}
+	return g, nil
+}
+
+func (p *parser) alternatives() (*expr, error) {
+	e, err := p.sequence()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek(0).text != "/" {
+		return e, nil
+	}
+	alt := &expr{kind: expr_first_of, kids: []*expr{e}}
+	for p.accept("/") {
+		if e, err = p.sequence(); err != nil {
+			return nil, err
+		}
+		alt.kids = append(alt.kids, e)
+	}
+	return alt, nil
+}
+
+func (p *parser) sequence() (*expr, error) {
+	seq := &expr{kind: expr_sequence}
+	for {
+		t := p.peek(0)
+		if t.kind == "eof" || t.kind == "punct" && (t.text == "/" || t.text == ")" || t.text == ",") || p.at_rule() {
+			break
+		}
+		e, err := p.postfix()
+		if err != nil {
+			return nil, err
+		}
+		seq.kids = append(seq.kids, e)
+	}
+	switch len(seq.kids) {
+	case 0:
+		return nil, p.fail(p.peek(0), "expression")
+	case 1:
+		return seq.kids[0], nil
+	default:
+		return seq, nil
+	}
+}
+
+func (p *parser) postfix() (*expr, error) {
+	e, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("*"):
+			e = &expr{kind: expr_zero_or_more, kids: []*expr{e}}
+		case p.accept("+"):
+			e = &expr{kind: expr_one_or_more, kids: []*expr{e}}
+		case p.accept("?"):
+			e = &expr{kind: expr_optional, kids: []*expr{e}}
+		default:
+			return e, nil
+		}
+	}
+}
+
+func (p *parser) primary() (*expr, error) {
+	t := p.next()
+	switch t.kind {
+	case "string":
+		s, err := unquote(t.text)
+		if err != nil || s == "" {
+			return nil, p.fail(t, "non-empty string")
+		}
+		return &expr{kind: expr_literal, text: s}, nil
+	case "class":
+		e, err := parse_class(t.text)
+		if err != nil {
+			return nil, p.fail(t, "valid character class")
+		}
+		return e, nil
+	case "punct":
+		switch t.text {
+		case ".":
+			return &expr{kind: expr_any}, nil
+		case "(":
+			e, err := p.alternatives()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, p.fail(p.peek(0), "')'")
+			}
+			return e, nil
+		}
+	case "ident":
+		switch t.text {
+		case "eol":
+			return &expr{kind: expr_eol}, nil
+		case "eof":
+			return &expr{kind: expr_eof}, nil
+		case "skip", "between":
+			return p.call(t)
+		}
+		return nil, p.fail(t, "expression instead of %q", t.text)
+	}
+	return nil, p.fail(t, "expression")
+}
+
+// call parses the arguments of skip(e) and between(prefix, terminator
+// [, content]).
+func (p *parser) call(t *token) (*expr, error) {
+	if !p.accept("(") {
+		return nil, p.fail(p.peek(0), "'('")
+	}
+	e := &expr{kind: expr_skip}
+	if t.text == "between" {
+		e.kind = expr_between
+	}
+	for {
+		arg, err := p.alternatives()
+		if err != nil {
+			return nil, err
+		}
+		e.kids = append(e.kids, arg)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if !p.accept(")") {
+		return nil, p.fail(p.peek(0), "')'")
+	}
+	if e.kind == expr_skip && len(e.kids) != 1 || e.kind == expr_between && (len(e.kids) < 2 || len(e.kids) > 3) {
+		return nil, p.fail(t, "valid number of %s arguments", t.text)
+	}
+	return e, nil
+}
+
+// unquote decodes the content of a quoted string with Go escape sequences.
+func unquote(s string) (string, error) {
+	rr, _, err := decode(s, `'"`)
+	return string(rr), err
+}
+
+// decode decodes Go escape sequences in s. Besides the Go escapes, a
+// backslash may precede any of the characters in extra and then stands for
+// the character itself. The escaped flags mark such characters.
+func decode(s, extra string) (rr []rune, escaped []bool, err error) {
+	for s != "" {
+		if len(s) > 1 && s[0] == '\\' && strings.IndexByte(extra, s[1]) >= 0 {
+			rr = append(rr, rune(s[1]))
+			escaped = append(escaped, true)
+			s = s[2:]
+			continue
+		}
+		c, _, tail, err := strconv.UnquoteChar(s, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		rr = append(rr, c)
+		escaped = append(escaped, false)
+		s = tail
+	}
+	return rr, escaped, nil
+}
+
+// parse_class parses the content of a character class: single characters
+// and ranges like a-z, optionally negated with a leading ^. The characters
+// ], -, and ^ can be escaped with a backslash.
+func parse_class(s string) (*expr, error) {
+	e := &expr{kind: expr_class}
+	if strings.HasPrefix(s, "^") {
+		e.negated = true
+		s = s[1:]
+	}
+	rr, escaped, err := decode(s, `'"]-^`)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(rr); i++ {
+		lo, hi := rr[i], rr[i]
+		if i+2 < len(rr) && rr[i+1] == '-' && !escaped[i+1] {
+			hi = rr[i+2]
+			i += 2
+		}
+		if hi < lo {
+			return nil, fmt.Errorf("invalid range")
+		}
+		e.ranges = append(e.ranges, rune_range{lo, hi})
+	}
+	if len(e.ranges) == 0 {
+		return nil, fmt.Errorf("empty class")
+	}
+	return e, nil
+}
+
+// nullable reports whether e may succeed without consuming anything. As
+// with the combinators, a sequence succeeds as soon as its first term does.
+func nullable(e *expr) bool {
+	switch e.kind {
+	case expr_zero_or_more, expr_optional, expr_eol, expr_eof:
+		return true
+	case expr_sequence, expr_one_or_more, expr_skip, expr_between:
+		return nullable(e.kids[0])
+	case expr_first_of:
+		for _, k := range e.kids {
+			if nullable(k) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+// Parsegen generates a standalone Go lexer from a grammar declared in PEG
+// text or in Go. The lexer produces the same tokens, locations and errors as
+// parse.Tokenize with the equivalent bindings and default options, but
+// matches with specialized code and does not depend on the parse package:
+//
+//	//go:generate go run github.com/adnsv/go-parse/cmd/parsegen -o lexer.go lexer.peg
+//
+// A grammar declared in Go is read from a registration file, a main package
+// with a .go extension that writes its bindings with parse.WriteGrammar to
+// the standard output. Parsegen runs it with go run in the directory of the
+// file, so it is usually excluded from the build with a go:build ignore
+// constraint:
+//
+//	//go:generate go run github.com/adnsv/go-parse/cmd/parsegen -o lexer.go lexer_grammar.go
+//
+// The bindings can only use the combinators that the expressions below map
+// to; the classes are found by calling the predicates for every codepoint.
+//
+// A grammar is an optional package clause followed by an ordered list of
+// rules, each one corresponding to a parse.Bind call. The description is
+// optional and defaults to the rule name:
+//
+//	package lexer
+//
+//	# comments start with a hash
+//	ws      "whitespace" <- skip([ \t\r\n]+)
+//	comment "comment"    <- between("//", eol)
+//	ident   "identifier" <- [a-zA-Z_] [a-zA-Z_0-9]*
+//	number  "number"     <- [0-9]+ ("." [0-9]+)?
+//	string  "string"     <- between('"', '"', ([^"\\] / "\\" .)*)
+//	punct   "punctuation" <- "(" / ")" / "==" / "="
+//
+// Expressions map to the parse combinators:
+//
+//	"text", 'text'        Literal, or Codepoint for a single character
+//	[a-z_], [^\n]         CodepointFunc with a character class
+//	.                     CodepointFunc matching any character
+//	e1 e2                 Sequence
+//	e1 / e2               FirstOf
+//	e*, e+, e?            ZeroOrMore, OneOrMore, Optional
+//	skip(e)               Skip
+//	between(p, t[, c])    Between
+//	eol, eof              EOL, EOF
+//
+// Strings and classes use Go escape sequences. Rules that can succeed
+// without consuming anything are rejected.
+//
+// The generated file declares a Kind type with a constant for each rule,
+// e.g. KindIdent, an Error type and a Tokenize function:
+//
+//	func Tokenize(buf []byte, on_token func(kind Kind, text string, line, col int)) error
+//
+// Its other package-level identifiers start with parsegen_, so that it can
+// share its package with other code.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/adnsv/go-parse/parse"
+)
+
+func main() {
+	out := flag.String("o", "", "output file, defaults to stdout")
+	pkg := flag.String("pkg", "", "package name, overrides the package clause of the grammar")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: parsegen [-o file] [-pkg name] grammar.peg|grammar.go\n\nThe grammar is PEG text, or a registration file that writes it with\nparse.WriteGrammar, see the package documentation.\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Arg(0), *out, *pkg); err != nil {
+		fmt.Fprintf(os.Stderr, "parsegen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(fn, out, pkg string) error {
+	src, err := read_grammar(fn)
+	if err != nil {
+		return err
+	}
+	g, err := parse_grammar(src)
+	if err != nil {
+		return parse.WithFile(err, fn)
+	}
+	switch {
+	case pkg != "":
+	case g.pkg != "":
+		pkg = g.pkg
+	default:
+		pkg = "lexer"
+	}
+	code, err := generate(g, pkg, filepath.Base(fn))
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = os.Stdout.Write(code)
+		return err
+	}
+	return os.WriteFile(out, code, 0o666)
+}
+
+// read_grammar returns the content of fn, or what it writes to the standard
+// output if it is a registration file.
+func read_grammar(fn string) ([]byte, error) {
+	if filepath.Ext(fn) != ".go" {
+		return os.ReadFile(fn)
+	}
+	cmd := exec.Command("go", "run", filepath.Base(fn))
+	cmd.Dir = filepath.Dir(fn)
+	stderr := bytes.Buffer{}
+	cmd.Stderr = &stderr
+	src, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go run %s: %v\n%s", fn, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return src, nil
+}
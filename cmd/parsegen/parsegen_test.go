@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adnsv/go-parse/parse"
+)
+
+// interpret builds the parse bindings equivalent to g, the reference for
+// the generated lexers.
+func interpret(g *grammar) []*parse.Binding[string] {
+	bb := make([]*parse.Binding[string], len(g.rules))
+	for i, r := range g.rules {
+		bb[i] = parse.Bind(r.name, r.descr, term(r.e))
+	}
+	return bb
+}
+
+func term(e *expr) parse.TermFunc {
+	kids := make([]any, len(e.kids))
+	for i, k := range e.kids {
+		kids[i] = term(k)
+	}
+	switch e.kind {
+	case expr_literal:
+		if rr := []rune(e.text); len(rr) == 1 {
+			return parse.Codepoint(rr[0])
+		}
+		return parse.Literal(e.text)
+	case expr_class:
+		return parse.CodepointFunc(e.has)
+	case expr_any:
+		return parse.CodepointFunc(func(rune) bool { return true })
+	case expr_sequence:
+		return parse.Sequence(kids...)
+	case expr_first_of:
+		return parse.FirstOf(kids...)
+	case expr_zero_or_more:
+		return parse.ZeroOrMore(kids[0].(parse.TermFunc))
+	case expr_one_or_more:
+		return parse.OneOrMore(kids[0].(parse.TermFunc))
+	case expr_optional:
+		return parse.Optional(kids[0].(parse.TermFunc))
+	case expr_skip:
+		return parse.Skip(kids[0])
+	case expr_between:
+		return parse.Between(kids[0], kids[1], kids[2:]...)
+	case expr_eol:
+		return parse.EOL
+	default:
+		return parse.EOF
+	}
+}
+
+// harness prints the tokens and the error for each of the inputs, in the
+// same format for the interpreted and the generated lexers.
+const harness = `package main
+
+import "fmt"
+
+func main() {
+	for _, in := range inputs {
+		err := Tokenize([]byte(in), func(kind Kind, text string, line, col int) {
+			fmt.Printf("%s:%q@%d:%d ", kind, text, line, col)
+		})
+		fmt.Printf("error: %v\n", err)
+	}
+}
+`
+
+func interpreted(g *grammar, inputs []string) string {
+	bb := interpret(g)
+	b := strings.Builder{}
+	for _, in := range inputs {
+		err := parse.Tokenize([]byte(in), bb, func(k string, c *parse.Context, lc parse.LineCol) {
+			fmt.Fprintf(&b, "%s:%q@%d:%d ", k, c.String(), lc.LineIndex, lc.ColumnIndex)
+		})
+		fmt.Fprintf(&b, "error: %v\n", err)
+	}
+	return b.String()
+}
+
+func generated(t *testing.T, g *grammar, inputs []string) string {
+	dir := t.TempDir()
+	code, err := generate(g, "main", "test.peg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := harness + "\nvar inputs = []string{\n"
+	for _, in := range inputs {
+		main += fmt.Sprintf("\t%q,\n", in)
+	}
+	main += "}\n"
+	for fn, content := range map[string]string{
+		"go.mod":   "module difftest\n\ngo 1.19\n",
+		"lexer.go": string(code),
+		"main.go":  main,
+		// names that the generated code must not collide with
+		"names.go": "package main\n\ntype lexer struct{}\n\nvar rules, none, code, unmatched, unterminated, kind_names int\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, fn), []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=", "GOTOOLCHAIN=local")
+	out, err := cmd.Output()
+	if err != nil {
+		if e, ok := err.(*exec.ExitError); ok {
+			t.Fatalf("go run: %v\n%s", err, e.Stderr)
+		}
+		t.Fatalf("go run: %v", err)
+	}
+	return string(out)
+}
+
+// lexer_tests are grammars with inputs on which the generated lexers must
+// produce the same output as the interpreted ones.
+var lexer_tests = []struct {
+	name    string
+	grammar string
+	inputs  []string
+}{
+	{"c", `
+		ws      "whitespace" <- skip([ \t\r\n]+)
+		slc     "single-line comment" <- between("//", eol)
+		mlc     "multi-line comment" <- between("/*", "*/")
+		ident   "identifier" <- [a-zA-Z_] [a-zA-Z_0-9]*
+		hex     "hex" <- "0x" [0-9a-fA-F]+
+		dec     "decimal" <- [0-9]+ ("." [0-9]+)?
+		chr     "char" <- between('\'', '\'', [^'\n]+)
+		str     "string" <- between('"', '"', ([^"\\\n] / "\\" .)*)
+		punct   "punctuation" <- "(" / ")" / "{" / "}" / ";" / "==" / "=" / "+=" / "++" / "+"
+	`, []string{
+		"", "int x = 0x1F;\nx += 2.5; // done\n",
+		"/* a\n * b */ f(x)", "/* open", "// eof", "s = \"a\\\"b\" + 'c'",
+		"'x", "'\n'", "\"ab", "x @ y", "0x", "1.", "a\r\nb",
+		"ж = 1", "x\xffy", "\"\xff\xbf\xbf\"", "'\xe2\x82'",
+	}},
+	{"unicode", `
+		package words
+		space <- skip(" "+)
+		word  "word" <- [a-zа-яё]+
+		arrow <- "→" / "->"
+		any   "symbol" <- [^ a-z]
+	`, []string{
+		"", "привет мир → hello", "a->b", "ёж\xffz", "\xe2\x86", "AB", "→→",
+	}},
+	{"lines", `
+		blank  "blank line" <- "\n"
+		entry  "entry" <- skip("- ") [^\n]* eol
+		header "header" <- between("[", "]", [a-z]+) eol
+		end    "end" <- "." eof
+	`, []string{
+		"", "[a]\n- x\n- y\n\n.", "[a]", "[ab\n", "[]\n", "[a] \n", ". ", "-x", "- ж\r\n",
+	}},
+	{"overlap", `
+		# the content of a between can fail after the prefix was consumed,
+		# the next rule then starts from there
+		q  "quoted" <- between("'", "'", [a-z]+)
+		n  "number" <- [0-9]+
+		x  <- "x" "y"
+		ab <- "a" "b" / "a" "c"
+	`, []string{
+		"'ab'", "'1", "''", "'x'y", "xy", "yy", "ab", "ac", "ad", "x", "xz",
+	}},
+}
+
+func TestGenerate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the generated lexers")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	for _, tt := range lexer_tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := parse_grammar([]byte(tt.grammar))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := interpreted(g, tt.inputs)
+			got := generated(t, g, tt.inputs)
+			if got == want {
+				return
+			}
+			wl, gl := strings.Split(want, "\n"), strings.Split(got, "\n")
+			for i, in := range tt.inputs {
+				if i >= len(gl) || gl[i] != wl[i] {
+					t.Errorf("%q: generated output differs\n\tgot  %s\n\twant %s", in, strings.Join(gl[i:], "\n"), wl[i])
+					break
+				}
+			}
+		})
+	}
+}
+
+// TestWriteGrammar checks that the grammar written from the interpreted
+// bindings reads back as an equivalent grammar.
+func TestWriteGrammar(t *testing.T) {
+	for _, tt := range lexer_tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := parse_grammar([]byte(tt.grammar))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := bytes.Buffer{}
+			if err := parse.WriteGrammar(&b, "", interpret(g)); err != nil {
+				t.Fatal(err)
+			}
+			g2, err := parse_grammar(b.Bytes())
+			if err != nil {
+				t.Fatalf("%v\n%s", err, b.String())
+			}
+			want := interpreted(g, tt.inputs)
+			if got := interpreted(g2, tt.inputs); got != want {
+				t.Errorf("written grammar differs\n%s\ngot  %s\nwant %s", b.String(), got, want)
+			}
+		})
+	}
+}
+
+const registration = `//go:build ignore
+
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/adnsv/go-parse/parse"
+)
+
+func is_digit(c rune) bool { return '0' <= c && c <= '9' }
+
+var bindings = []*parse.Binding[string]{
+	parse.Bind("ws", "whitespace", parse.Skip(parse.OneOrMore(' '))),
+	parse.Bind("number", "number", parse.OneOrMore(is_digit), parse.Optional(parse.Sequence('.', parse.OneOrMore(is_digit)))),
+	parse.Bind("op", "operator", parse.FirstOf("**", '*', '+')),
+}
+
+func main() {
+	if err := parse.WriteGrammar(os.Stdout, "calc", bindings); err != nil {
+		log.Fatal(err)
+	}
+}
+`
+
+func TestRegistration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the registration file")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	root, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	t.Setenv("GOFLAGS", "")
+	t.Setenv("GOTOOLCHAIN", "local")
+	for fn, content := range map[string]string{
+		"go.mod": "module regtest\n\ngo 1.19\n\nrequire github.com/adnsv/go-parse v0.0.0\n\n" +
+			"replace github.com/adnsv/go-parse => " + root + "\n",
+		"calc_grammar.go": registration,
+		"calc.peg": `
+			package calc
+			ws     "whitespace" <- skip(" "+)
+			number "number"     <- [0-9]+ ("." [0-9]+)?
+			op     "operator"   <- "**" / "*" / "+"
+		`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, fn), []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, fn := range []string{"calc.peg", "calc_grammar.go"} {
+		if err := run(filepath.Join(dir, fn), filepath.Join(dir, fn+".out"), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, _ := os.ReadFile(filepath.Join(dir, "calc.peg.out"))
+	got, _ := os.ReadFile(filepath.Join(dir, "calc_grammar.go.out"))
+	want = bytes.Replace(want, []byte("calc.peg"), []byte("calc_grammar.go"), -1)
+	if !bytes.Equal(got, want) {
+		t.Errorf("generated from the registration file:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParseGrammar(t *testing.T) {
+	tests := []struct {
+		grammar string
+		want    string
+	}{
+		{`a <- "x"`, ""},
+		{`a "first" <- "x" b <- [^a-c\]-]`, ""},
+		{``, "[1:1] expected rule"},
+		{`a <- "x" a <- "y"`, `[1:10] expected unique rule name instead of "a"`},
+		{`a <- "x"*`, `[1:1] expected rule "a" that does not match empty content`},
+		{`a <- eol "x"`, `[1:1] expected rule "a" that does not match empty content`},
+		{`a <- ("x"`, "[1:10] expected ')'"},
+		{`a <- between("x")`, "[1:6] expected valid number of between arguments"},
+		{`a <- [z-a]`, "[1:6] expected valid character class"},
+		{`a <- ""`, "[1:6] expected non-empty string"},
+		{`a <- b`, `[1:6] expected expression instead of "b"`},
+		{`a <- "x" @`, "[1:10] expected whitespace, comment, identifier, '<-', string, character class or punctuation"},
+	}
+	for _, tt := range tests {
+		_, err := parse_grammar([]byte(tt.grammar))
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("parse_grammar(%q) error = %q, want %q", tt.grammar, got, tt.want)
+		}
+	}
+}
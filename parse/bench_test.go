@@ -0,0 +1,192 @@
+package parse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// The benchmarks tokenize synthetic corpora of about 1 MB each and report
+// throughput with b.SetBytes. The throughput depends on the machine, so it
+// is tracked against a recorded baseline rather than fixed figures:
+// testdata/bench_baseline.txt holds the output of
+//
+//	go test -run XXX -bench . -benchmem -count 10
+//
+// on a single shared x86-64 virtual core. Changes are measured the same way
+// on the same machine and compared with benchstat; a significant slowdown is
+// a regression:
+//
+//	benchstat testdata/bench_baseline.txt new.txt
+//
+// BenchmarkTokenizeParallelLog is expected to scale with GOMAXPROCS from the
+// BenchmarkTokenizeLog figure.
+//
+// Tokenization of verbatim tokens must not allocate per token; the remaining
+// allocations come from escaped strings and from the values appended by
+// numeric and date terms.
+
+const bench_size = 1 << 20
+
+func repeat_to(size int, chunk func(i int) string) []byte {
+	b := strings.Builder{}
+	for i := 0; b.Len() < size; i++ {
+		b.WriteString(chunk(i))
+	}
+	return []byte(b.String())
+}
+
+func bench_json() []byte {
+	return repeat_to(bench_size, func(i int) string {
+		return fmt.Sprintf(`{"id": %d, "name": "item \"%d\"", "tags": ["a", "b\n"], `+
+			`"price": %d.%02d, "active": true, "parent": null}`+",\n", i, i, i%1000, i%100)
+	})
+}
+
+func bench_c() []byte {
+	return repeat_to(bench_size, func(i int) string {
+		return fmt.Sprintf(`/* function %d */
+static int func_%d(const char *s, int n) {
+	// count the matches
+	int count = 0x%x;
+	for (int i = 0; i < n; i += 1) {
+		if (s[i] == 'x' && n >= %d) count++;
+	}
+	return count; /* done */
+}
+
+`, i, i, i, i%50)
+	})
+}
+
+func bench_log() []byte {
+	return repeat_to(bench_size, func(i int) string {
+		return fmt.Sprintf("2023-10-%02d 12:%02d:%02d INFO  [worker-%d] request id=%d path=/api/v1/items status=200 took=%dms\n",
+			1+i%28, i%60, i%60, i%8, i, i%500)
+	})
+}
+
+func is_ws(c rune) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\r' }
+
+func is_id_start(c rune) bool { return is_alpha(c) || c == '_' }
+
+func is_id_cont(c rune) bool { return is_alpha(c) || is_dec(c) || c == '_' }
+
+func json_bindings() []*Binding[string] {
+	return []*Binding[string]{
+		Bind("ws", "whitespace", Skip(OneOrMore(is_ws))),
+		Bind("str", "string", Between('"', '"', ZeroOrMore(FirstOf(
+			Escaped('\\', map[rune]any{
+				'"': '"', '\\': '\\', '/': '/', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t',
+				'u': HexCodepoint_XXXX,
+			}),
+			func(c rune) bool { return c >= ' ' && c != '"' && c != '\\' },
+		)))),
+		Bind("num", "number", Uint[uint64]("", 10, 1<<63), Optional(Sequence('.', OneOrMore(is_dec)))),
+		Bind("kw", "keyword", AnyOf("true", "false", "null")),
+		Bind("punct", "punct", AnyOf("{", "}", "[", "]", ":", ",")),
+	}
+}
+
+func c_bindings() []*Binding[string] {
+	return []*Binding[string]{
+		Bind("ws", "whitespace", Skip(OneOrMore(is_ws))),
+		Bind("slc", "single-line comment", Between("//", EOL)),
+		Bind("mlc", "multi-line comment", Between("/*", "*/")),
+		Bind("id", "ident", is_id_start, ZeroOrMore(is_id_cont)),
+		Bind("hex", "hex", Uint[uint64]("0x", 16, 1<<63)),
+		Bind("dec", "decimal", Uint[uint64]("", 10, 1<<63)),
+		Bind("chr", "char", Between('\'', '\'', OneOrMore(func(c rune) bool { return c != '\'' && c != '\n' }))),
+		Bind("punct", "punct", AnyOf("(", ")", "{", "}", "[", "]", ";", ",", "*", "=", "==", "+=", "++",
+			"<", "<=", ">", ">=", "&&", "&", "+")),
+	}
+}
+
+func log_bindings() []*Binding[string] {
+	return []*Binding[string]{
+		Bind("ws", "whitespace", Skip(OneOrMore(is_ws))),
+		Bind("time", "timestamp", DateTime),
+		Bind("level", "level", AnyOf("DEBUG", "INFO", "WARN", "ERROR")),
+		Bind("tag", "tag", Between('[', ']')),
+		Bind("field", "field", OneOrMore(is_id_cont), '=', OneOrMore(func(c rune) bool { return c > ' ' })),
+	}
+}
+
+func bench_tokenize(b *testing.B, buf []byte, bb []*Binding[string]) {
+	n := 0
+	on_token := func(k string, c *Context, lc LineCol) { n++ }
+	if err := Tokenize(buf, bb, on_token); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Tokenize(buf, bb, on_token)
+	}
+}
+
+func BenchmarkTokenizeJSON(b *testing.B) { bench_tokenize(b, bench_json(), json_bindings()) }
+
+func BenchmarkTokenizeC(b *testing.B) { bench_tokenize(b, bench_c(), c_bindings()) }
+
+func BenchmarkTokenizeLog(b *testing.B) { bench_tokenize(b, bench_log(), log_bindings()) }
+
+func BenchmarkTokenizeParallelLog(b *testing.B) {
+	buf, bb := bench_log(), log_bindings()
+	on_token := func(k string, c *Context, lc LineCol) {}
+	opts := &ParallelOptions{ChunkSize: 64 << 10}
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := TokenizeParallel(context.Background(), buf, bb, on_token, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTokenizeCCompiled(b *testing.B) {
+	bb := c_bindings()
+	for i, binding := range bb {
+		bb[i] = Bind(binding.k, binding.descr, Compile(binding.c))
+	}
+	bench_tokenize(b, bench_c(), bb)
+}
+
+func BenchmarkSourceLeap(b *testing.B) {
+	buf := repeat_to(bench_size, func(int) string { return "lorem ipsum\n" })
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		src := Static(buf, nil)
+		for src.Leap("lorem ") && src.Leap("ipsum\n") {
+		}
+	}
+}
+
+func BenchmarkSourceFetch(b *testing.B) {
+	buf := repeat_to(bench_size, func(int) string { return "lorem ipsum, ÿ€😀\n" })
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		src := Static(buf, nil)
+		for src.Fetch(nil) != Unmatched {
+		}
+	}
+}
+
+func TestTokenizeAllocs(t *testing.T) {
+	buf := []byte("alpha beta\ngamma /* delta */ epsilon; // zeta\n")
+	bb := c_bindings()
+	n := testing.AllocsPerRun(10, func() {
+		Tokenize(buf, bb, func(k string, c *Context, lc LineCol) {})
+	})
+	// the source, the context and the expectations
+	if n > 4 {
+		t.Errorf("Tokenize() allocs = %v, want at most 4", n)
+	}
+}
@@ -6,21 +6,30 @@ import (
 )
 
 func Codepoint(r rune) TermFunc {
+	n := &node{op: op_rune, r: r, what: []string{quote_term(string(r))}}
 	return func(src Source, ctx *Context) ErrCode {
-		if src.Hop(r) {
+		if d, ok := src.(*describe); ok {
+			return d.reply(n)
+		}
+		if src.Hop(n.r) {
 			if ctx != nil {
-				ctx.WriteRune(r)
+				ctx.WriteRune(n.r)
 			}
 			return ErrCodeNone
 		} else {
+			ctx.Expect(src, n.what[0])
 			return ErrCodeUnmatched
 		}
 	}
 }
 
 func CodepointFunc(m func(rune) bool) TermFunc {
+	n := &node{op: op_pred, pred: m}
 	return func(src Source, ctx *Context) ErrCode {
-		if r := src.Fetch(m); r == Unmatched {
+		if d, ok := src.(*describe); ok {
+			return d.reply(n)
+		}
+		if r := src.Fetch(n.pred); r == Unmatched {
 			return ErrCodeUnmatched
 		} else if ctx != nil {
 			ctx.WriteRune(r)
@@ -33,13 +42,18 @@ func Literal(s string) TermFunc {
 	if len(s) == 0 {
 		panic("empty literal term is not allowed")
 	}
+	n := &node{op: op_literal, s: s, what: []string{quote_term(s)}}
 	return func(src Source, ctx *Context) ErrCode {
-		if src.Leap(s) {
+		if d, ok := src.(*describe); ok {
+			return d.reply(n)
+		}
+		if src.Leap(n.s) {
 			if ctx != nil {
-				ctx.WriteString(s)
+				ctx.WriteString(n.s)
 			}
 			return ErrCodeNone
 		} else {
+			ctx.Expect(src, n.what[0])
 			return ErrCodeUnmatched
 		}
 	}
@@ -89,12 +103,16 @@ func Sequence(args ...any) TermFunc {
 	} else {
 		first := asTermFunc(args[0])
 		rest := asTermFuncs(args[1:]...)
+		n := &node{op: op_sequence, kids: append([]TermFunc{first}, rest...)}
 		return func(src Source, ctx *Context) ErrCode {
-			ec := first(src, ctx)
+			if d, ok := src.(*describe); ok {
+				return d.reply(n)
+			}
+			ec := n.kids[0](src, ctx)
 			if ec == ErrCodeUnmatched {
 				return ErrCodeUnmatched
 			}
-			for _, m := range rest {
+			for _, m := range n.kids[1:] {
 				ec = m(src, ctx)
 				if ec == ErrCodeUnmatched {
 					return ErrCodeNone
@@ -109,9 +127,12 @@ func Sequence(args ...any) TermFunc {
 
 // Optional matches zero or one: (a)?
 func Optional[T Term](a T) TermFunc {
-	v := asTermFunc(a)
+	n := &node{op: op_optional, kids: []TermFunc{asTermFunc(a)}}
 	return func(src Source, ctx *Context) ErrCode {
-		ec := v(src, ctx)
+		if d, ok := src.(*describe); ok {
+			return d.reply(n)
+		}
+		ec := n.kids[0](src, ctx)
 		if ec == ErrCodeUnmatched {
 			ec = ErrCodeNone
 		}
@@ -142,9 +163,18 @@ func AnyOf(args ...string) TermFunc {
 		})
 	}
 
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quote_term(arg)
+	}
+
+	n := &node{op: op_any_of, alts: matchers, what: quoted}
 	return func(src Source, ctx *Context) ErrCode {
+		if d, ok := src.(*describe); ok {
+			return d.reply(n)
+		}
 		if c := src.Peek(); c != Unmatched {
-			if mm, ok := matchers[c]; ok {
+			if mm, ok := n.alts[c]; ok {
 				for _, m := range mm {
 					if src.Leap(m) {
 						if ctx != nil {
@@ -155,13 +185,20 @@ func AnyOf(args ...string) TermFunc {
 				}
 			}
 		}
+		for _, what := range n.what {
+			ctx.Expect(src, what)
+		}
 		return ErrCodeUnmatched
 	}
 }
 
 func OneOrMore[T Term](a T) TermFunc {
-	v := asTermFunc(a)
+	n := &node{op: op_one_or_more, kids: []TermFunc{asTermFunc(a)}}
 	return func(src Source, ctx *Context) ErrCode {
+		if d, ok := src.(*describe); ok {
+			return d.reply(n)
+		}
+		v := n.kids[0]
 		ec := v(src, ctx)
 		if ec != ErrCodeNone {
 			return ec
@@ -178,8 +215,12 @@ func OneOrMore[T Term](a T) TermFunc {
 }
 
 func ZeroOrMore[T Term](a T) TermFunc {
-	v := asTermFunc(a)
+	n := &node{op: op_zero_or_more, kids: []TermFunc{asTermFunc(a)}}
 	return func(src Source, ctx *Context) ErrCode {
+		if d, ok := src.(*describe); ok {
+			return d.reply(n)
+		}
+		v := n.kids[0]
 		for {
 			ec := v(src, ctx)
 			if ec == ErrCodeUnmatched {
@@ -198,9 +239,15 @@ func FirstOf(args ...any) TermFunc {
 	case 1:
 		return asTermFunc(args[0])
 	default:
-		vv := asTermFuncs(args...)
+		n := &node{op: op_first_of, kids: asTermFuncs(args...)}
 		return func(src Source, ctx *Context) ErrCode {
-			for _, v := range vv {
+			if d, ok := src.(*describe); ok {
+				return d.reply(n)
+			}
+			if s, _ := src.(*static_impl); s != nil && s.tick() {
+				return ErrCodeUnmatched
+			}
+			for _, v := range n.kids {
 				ec := v(src, ctx)
 				if ec == ErrCodeUnmatched {
 					continue
@@ -214,14 +261,21 @@ func FirstOf(args ...any) TermFunc {
 }
 
 func EOF(src Source, ctx *Context) ErrCode {
+	if d, ok := src.(*describe); ok {
+		return d.reply(eof_node)
+	}
 	if src.Done() {
 		return ErrCodeNone
 	} else {
+		ctx.Expect(src, "end of input")
 		return ErrCodeUnmatched
 	}
 }
 
 func EOL(src Source, ctx *Context) ErrCode {
+	if d, ok := src.(*describe); ok {
+		return d.reply(eol_node)
+	}
 	switch {
 	case src.Done():
 		return ErrCodeNone
@@ -236,6 +290,31 @@ func EOL(src Source, ctx *Context) ErrCode {
 		}
 		return ErrCodeNone
 	default:
+		ctx.Expect(src, "end of line")
+		return ErrCodeUnmatched
+	}
+}
+
+// LineEnd creates a matcher for the end of input or any of the specified line
+// terminators. Similar to EOL, the terminator is captured.
+func LineEnd(breaks LineBreaks) TermFunc {
+	return func(src Source, ctx *Context) ErrCode {
+		if src.Done() {
+			return ErrCodeNone
+		}
+		if breaks&BreakCR != 0 && src.Leap("\r\n") {
+			if ctx != nil {
+				ctx.WriteString("\r\n")
+			}
+			return ErrCodeNone
+		}
+		if c := src.Fetch(breaks.has); c != Unmatched {
+			if ctx != nil {
+				ctx.WriteRune(c)
+			}
+			return ErrCodeNone
+		}
+		ctx.Expect(src, "end of line")
 		return ErrCodeUnmatched
 	}
 }
@@ -245,18 +324,23 @@ func Between(prefix, terminator any, content ...any) TermFunc {
 
 	if len(content) == 0 {
 		terminator_v := asTermFunc(terminator)
+		n := &node{op: op_between, kids: []TermFunc{prefix_v, terminator_v}}
 		return func(src Source, ctx *Context) ErrCode {
-			ec := prefix_v(src, nil)
+			if d, ok := src.(*describe); ok {
+				return d.reply(n)
+			}
+			prefix_v, terminator_v := n.kids[0], n.kids[1]
+			ec := muted(prefix_v, src, ctx)
 			if ec != ErrCodeNone {
 				return ec
 			}
 			for {
-				ec = terminator_v(src, nil)
+				ec = muted(terminator_v, src, ctx)
 				if ec == ErrCodeNone {
 					return ec
 				} else if r := src.Fetch(nil); r == Unmatched {
 					return ErrCodeUnterminated
-				} else {
+				} else if ctx != nil {
 					ctx.WriteRune(r)
 				}
 			}
@@ -264,8 +348,13 @@ func Between(prefix, terminator any, content ...any) TermFunc {
 	} else {
 		terminator_v := asOptTermFunc(terminator)
 		content_v := Sequence(content...)
+		n := &node{op: op_between, kids: []TermFunc{prefix_v, terminator_v, content_v}}
 		return func(src Source, ctx *Context) ErrCode {
-			ec := prefix_v(src, nil)
+			if d, ok := src.(*describe); ok {
+				return d.reply(n)
+			}
+			prefix_v, terminator_v, content_v := n.kids[0], n.kids[1], n.kids[2]
+			ec := muted(prefix_v, src, ctx)
 			if ec != ErrCodeNone {
 				return ec
 			}
@@ -273,7 +362,7 @@ func Between(prefix, terminator any, content ...any) TermFunc {
 			ec = content_v(src, ctx) // capturing
 
 			if ec == ErrCodeNone {
-				ec = terminator_v(src, nil)
+				ec = muted(terminator_v, src, ctx)
 				if ec == ErrCodeUnmatched {
 					ec = ErrCodeUnterminated
 				}
@@ -285,8 +374,47 @@ func Between(prefix, terminator any, content ...any) TermFunc {
 }
 
 func Skip(content ...any) TermFunc {
-	v := Sequence(content...)
+	n := &node{op: op_skip, kids: []TermFunc{Sequence(content...)}}
 	return func(src Source, ctx *Context) ErrCode {
-		return v(src, nil) // not capturing
+		if d, ok := src.(*describe); ok {
+			return d.reply(n)
+		}
+		return muted(n.kids[0], src, ctx) // not capturing
+	}
+}
+
+// Recursive creates a term that can refer to itself, for nested constructs
+// such as bracketed lists:
+//
+//	list := Recursive(func(list TermFunc) TermFunc {
+//		return Sequence('(', ZeroOrMore(FirstOf(list, is_alpha)), ')')
+//	})
+//
+// With static sources, the nesting depth is bounded by Limits.MaxDepth;
+// exceeding it stops the source with ErrDepth.
+func Recursive(build func(self TermFunc) TermFunc) TermFunc {
+	var body TermFunc
+	self := func(src Source, ctx *Context) ErrCode {
+		if _, ok := src.(*describe); ok {
+			// keep Compile from looking through to body, see node_of
+			return ErrCodeUnmatched
+		}
+		s, _ := src.(*static_impl)
+		if s == nil {
+			return body(src, ctx)
+		}
+		if s.tick() {
+			return ErrCodeUnmatched
+		}
+		if s.depth++; s.limits.MaxDepth > 0 && s.depth > s.limits.MaxDepth {
+			s.depth--
+			s.fail(ErrDepth)
+			return ErrCodeUnmatched
+		}
+		ec := body(src, ctx)
+		s.depth--
+		return ec
 	}
+	body = build(self)
+	return self
 }
@@ -0,0 +1,707 @@
+package parse
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// node describes the structure of a term created by one of the combinators,
+// so that Compile can look through the closure. The term reads its operands
+// from the node and reports the node when it is called with a describe
+// source, see node_of.
+type node struct {
+	op       node_op
+	r        rune
+	pred     func(rune) bool
+	s        string
+	kids     []TermFunc
+	alts     map[rune][]string // AnyOf alternatives by the first codepoint
+	what     []string          // the expectations when unmatched
+	escapes  map[rune]string   // Escaped literals
+	functors map[rune]TermFunc // Escaped functors
+}
+
+type node_op int
+
+const (
+	op_rune = node_op(iota)
+	op_pred
+	op_literal
+	op_sequence
+	op_first_of
+	op_optional
+	op_zero_or_more
+	op_one_or_more
+	op_skip
+	op_any_of
+	op_between
+	op_escaped
+	op_eol
+	op_eof
+)
+
+// describe is the source with which node_of asks a term for its node. The
+// terms created by the combinators reply without reading anything; to other
+// terms, it is an empty source that records whether it was read.
+type describe struct {
+	n    *node
+	code ErrCode // the code to reply with
+	read bool
+}
+
+// reply stores n and returns the code that the caller of the term expects,
+// see node_of.
+func (d *describe) reply(n *node) ErrCode {
+	d.n = n
+	return d.code
+}
+
+func (d *describe) Done() bool                        { d.read = true; return true }
+func (d *describe) Peek() rune                        { d.read = true; return Unmatched }
+func (d *describe) Hop(rune) bool                     { d.read = true; return false }
+func (d *describe) Leap(string) bool                  { d.read = true; return false }
+func (d *describe) Fetch(func(rune) bool) rune        { d.read = true; return Unmatched }
+func (d *describe) Skip(string, func(rune) bool) rune { d.read = true; return Unmatched }
+
+// node_of returns the node of a term created by one of the combinators, or
+// nil for other terms.
+//
+// The term is called with a describe source and an empty context, once for
+// each code that a term can return. A custom term that calls a combinator
+// term with the same source and context is accepted in its place if it
+// returns each code unchanged without reading the source or writing to the
+// context, as it then does not add anything that Compile would lose.
+func node_of(t TermFunc) *node {
+	if t == nil {
+		return nil
+	}
+	var n *node
+	for _, code := range [...]ErrCode{ErrCodeNone, ErrCodeUnmatched, ErrCodeInvalid} {
+		d := describe{code: code}
+		ctx := Context{}
+		if t(&d, &ctx) != code || d.n == nil || n != nil && d.n != n || d.read ||
+			ctx.Len() != 0 || len(ctx.Values) != 0 || ctx.failure != (failure{}) {
+			return nil
+		}
+		n = d.n
+	}
+	return n
+}
+
+// Compile lowers a term built with Codepoint, CodepointFunc, Literal,
+// Sequence, FirstOf, Optional, ZeroOrMore, OneOrMore, Skip, AnyOf and
+// Between into a table-driven bytecode matcher:
+//
+//	Bind("id", "ident", Compile(Sequence(id_start, ZeroOrMore(id_cont))))
+//
+// Codepoints and predicates, including FirstOf alternatives consisting of
+// them, become character classes with an ASCII lookup table, and repetitions
+// of a class consume a run of ASCII content from a Static source without
+// calling back per codepoint. Between with a literal terminator searches for
+// the terminator directly. Any other TermFunc found in the tree, such as
+// Escaped, Uint or a custom term, is called as is.
+//
+// To find the structure of the tree, Compile calls its terms with a source
+// that reports the end of input and an empty Context, so custom terms must
+// not have side effects beyond the source and the context. A custom term
+// that only calls another term is looked through.
+//
+// The compiled matcher consumes the same content and produces the same
+// captures and error codes as the original term. When Tokenize collects the
+// expectations for an error message, the original term is used. Predicates
+// are evaluated for ASCII codepoints once, during compilation, so they must
+// be pure functions of their argument.
+func Compile(term any) TermFunc {
+	t := asTermFunc(term)
+	if node_of(t) == nil {
+		return t
+	}
+	c := compiler{
+		prog:     &program{},
+		classes:  map[*node]*char_class{},
+		firsts:   map[*node]*ascii_set{},
+		emitting: map[*node]bool{},
+	}
+	c.emit_term(t)
+	prog := c.prog
+	return func(src Source, ctx *Context) ErrCode {
+		if ctx != nil && ctx.track != nil {
+			return t(src, ctx)
+		}
+		return prog.run(src, ctx)
+	}
+}
+
+// char_class matches a single codepoint. Codepoints below utf8.RuneSelf are
+// looked up in the table, the rest are checked against the members.
+type char_class struct {
+	ascii   [utf8.RuneSelf]bool
+	members []class_member
+	match   func(rune) bool
+}
+
+type class_member struct {
+	r    rune
+	pred func(rune) bool
+}
+
+func (cl *char_class) has(c rune) bool {
+	if c < utf8.RuneSelf {
+		return cl.ascii[c]
+	}
+	for _, m := range cl.members {
+		if m.pred != nil && m.pred(c) || m.pred == nil && m.r == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (cl *char_class) merge(other *char_class) {
+	for i, ok := range other.ascii {
+		cl.ascii[i] = cl.ascii[i] || ok
+	}
+	cl.members = append(cl.members, other.members...)
+}
+
+type opcode int
+
+const (
+	// ec := result of matching a codepoint from classes[arg]
+	opc_class = opcode(iota)
+	// ec := result of matching a run of at least min codepoints from
+	// classes[arg]
+	opc_span
+	// ec := result of matching literals[arg]
+	opc_literal
+	// ec := result of matching any of the alternatives in any_of[arg]
+	opc_any_of
+	// ec := terms[arg](src, ctx)
+	opc_call
+	// jump to arg
+	opc_jump
+	opc_mute
+	opc_unmute
+	// jump to arg unless ec is ErrCodeNone
+	opc_jump_unless_none
+	// jump to arg if ec is ErrCodeNone
+	opc_jump_if_none
+	// jump to arg unless ec is ErrCodeUnmatched
+	opc_jump_unless_unmatched
+	// turn ErrCodeUnmatched into ErrCodeNone and jump to arg, or jump to
+	// arg on errors; the rest of a Sequence
+	opc_sequence_next
+	// turn ErrCodeUnmatched into ErrCodeNone
+	opc_optional
+	// set ErrCodeUnmatched and jump to arg if the next codepoint is ASCII
+	// and not in firsts[min]
+	opc_guard
+	// turn ErrCodeUnmatched into ErrCodeUnterminated
+	opc_unterminated
+	// capture any codepoint, or set ErrCodeUnterminated and jump to arg at
+	// the end of input
+	opc_fetch_any
+	// capture the content up to and consume literals[min], then set ec and
+	// jump to arg; fall through if the content cannot be consumed directly
+	opc_scan_to
+)
+
+type instruction struct {
+	op  opcode
+	arg int
+	min int
+}
+
+type program struct {
+	code     []instruction
+	classes  []*char_class
+	literals []string
+	any_of   []*any_table
+	firsts   []*ascii_set
+	terms    []TermFunc
+}
+
+type ascii_set = [utf8.RuneSelf]bool
+
+// any_table lists the alternatives of AnyOf by the first codepoint, longest
+// first.
+type any_table struct {
+	ascii [utf8.RuneSelf][]string
+	other map[rune][]string
+}
+
+type compiler struct {
+	prog *program
+
+	// the results of class_of and first, nil while in progress, and the
+	// nodes being emitted, so that terms that refer to themselves through
+	// custom terms are not expanded forever
+	classes  map[*node]*char_class
+	firsts   map[*node]*ascii_set
+	emitting map[*node]bool
+}
+
+func (c *compiler) emit(op opcode, arg int) int {
+	c.prog.code = append(c.prog.code, instruction{op: op, arg: arg})
+	return len(c.prog.code) - 1
+}
+
+// patch sets the jump targets of the instructions at pcs to the current end
+// of the program.
+func (c *compiler) patch(pcs []int) {
+	for _, pc := range pcs {
+		c.prog.code[pc].arg = len(c.prog.code)
+	}
+}
+
+func (c *compiler) emit_class(cl *char_class) int {
+	cl.match = cl.has
+	c.prog.classes = append(c.prog.classes, cl)
+	return len(c.prog.classes) - 1
+}
+
+// class_of returns the character class equivalent to t, if there is one.
+func (c *compiler) class_of(t TermFunc) *char_class {
+	n := node_of(t)
+	if n == nil {
+		return nil
+	}
+	if cl, ok := c.classes[n]; ok {
+		return cl
+	}
+	c.classes[n] = nil
+	cl := c.node_class(n)
+	c.classes[n] = cl
+	return cl
+}
+
+func (c *compiler) node_class(n *node) *char_class {
+	switch n.op {
+	case op_rune:
+		cl := &char_class{}
+		if n.r >= 0 && n.r < utf8.RuneSelf {
+			cl.ascii[n.r] = true
+		} else {
+			cl.members = []class_member{{r: n.r}}
+		}
+		return cl
+	case op_pred:
+		cl := &char_class{members: []class_member{{pred: n.pred}}}
+		for i := range cl.ascii {
+			cl.ascii[i] = n.pred(rune(i))
+		}
+		return cl
+	case op_first_of:
+		cl := &char_class{}
+		for _, k := range n.kids {
+			kc := c.class_of(k)
+			if kc == nil {
+				return nil
+			}
+			cl.merge(kc)
+		}
+		return cl
+	}
+	return nil
+}
+
+// first returns the set of ASCII codepoints that t may start with. It
+// returns nil if t may match empty content or its structure is unknown.
+// When the next codepoint is ASCII and not in the set, t is unmatched
+// without consuming anything.
+func (c *compiler) first(t TermFunc) *ascii_set {
+	if cl := c.class_of(t); cl != nil {
+		return &cl.ascii
+	}
+	n := node_of(t)
+	if n == nil {
+		return nil
+	}
+	if set, ok := c.firsts[n]; ok {
+		return set
+	}
+	c.firsts[n] = nil
+	set := c.node_first(n)
+	c.firsts[n] = set
+	return set
+}
+
+func (c *compiler) node_first(n *node) *ascii_set {
+	set := &ascii_set{}
+	switch n.op {
+	case op_literal:
+		if n.s[0] < utf8.RuneSelf {
+			set[n.s[0]] = true
+		}
+	case op_escaped:
+		if n.r >= 0 && n.r < utf8.RuneSelf {
+			set[n.r] = true
+		}
+	case op_any_of:
+		for r := range n.alts {
+			if r < utf8.RuneSelf {
+				set[r] = true
+			}
+		}
+	case op_sequence, op_one_or_more, op_skip, op_between:
+		return c.first(n.kids[0])
+	case op_first_of:
+		for _, k := range n.kids {
+			kf := c.first(k)
+			if kf == nil {
+				return nil
+			}
+			for i, ok := range kf {
+				set[i] = set[i] || ok
+			}
+		}
+	default:
+		return nil
+	}
+	return set
+}
+
+func (c *compiler) emit_term(t TermFunc) {
+	if cl := c.class_of(t); cl != nil {
+		c.emit(opc_class, c.emit_class(cl))
+		return
+	}
+	n := node_of(t)
+	if n == nil || c.emitting[n] {
+		c.prog.terms = append(c.prog.terms, t)
+		c.emit(opc_call, len(c.prog.terms)-1)
+		return
+	}
+	c.emitting[n] = true
+	defer delete(c.emitting, n)
+	if n.op != op_literal && n.op != op_any_of {
+		// reject mismatches early, without running the whole term
+		if set := c.first(t); set != nil {
+			c.prog.firsts = append(c.prog.firsts, set)
+			pc := c.emit(opc_guard, 0)
+			c.prog.code[pc].min = len(c.prog.firsts) - 1
+			defer c.patch([]int{pc})
+		}
+	}
+	switch n.op {
+	case op_literal:
+		c.prog.literals = append(c.prog.literals, n.s)
+		c.emit(opc_literal, len(c.prog.literals)-1)
+
+	case op_sequence:
+		c.emit_term(n.kids[0])
+		exits := []int{c.emit(opc_jump_unless_none, 0)}
+		for _, k := range n.kids[1:] {
+			c.emit_term(k)
+			exits = append(exits, c.emit(opc_sequence_next, 0))
+		}
+		c.patch(exits)
+
+	case op_first_of:
+		exits := []int{}
+		for i, k := range n.kids {
+			c.emit_term(k)
+			if i < len(n.kids)-1 {
+				exits = append(exits, c.emit(opc_jump_unless_unmatched, 0))
+			}
+		}
+		c.patch(exits)
+
+	case op_optional:
+		c.emit_term(n.kids[0])
+		c.emit(opc_optional, 0)
+
+	case op_zero_or_more, op_one_or_more:
+		min := 0
+		if n.op == op_one_or_more {
+			min = 1
+		}
+		if cl := c.class_of(n.kids[0]); cl != nil {
+			pc := c.emit(opc_span, c.emit_class(cl))
+			c.prog.code[pc].min = min
+			return
+		}
+		exits := []int{}
+		if min > 0 {
+			c.emit_term(n.kids[0])
+			exits = append(exits, c.emit(opc_jump_unless_none, 0))
+		}
+		loop := len(c.prog.code)
+		c.emit_term(n.kids[0])
+		c.emit(opc_jump_if_none, loop)
+		c.emit(opc_optional, 0)
+		c.patch(exits)
+
+	case op_skip:
+		c.emit(opc_mute, 0)
+		c.emit_term(n.kids[0])
+		c.emit(opc_unmute, 0)
+
+	case op_any_of:
+		tab := &any_table{other: map[rune][]string{}}
+		for r, alts := range n.alts {
+			if r < utf8.RuneSelf {
+				tab.ascii[r] = alts
+			} else {
+				tab.other[r] = alts
+			}
+		}
+		c.prog.any_of = append(c.prog.any_of, tab)
+		c.emit(opc_any_of, len(c.prog.any_of)-1)
+
+	case op_between:
+		prefix, terminator := n.kids[0], n.kids[1]
+		c.emit(opc_mute, 0)
+		c.emit_term(prefix)
+		c.emit(opc_unmute, 0)
+		exits := []int{c.emit(opc_jump_unless_none, 0)}
+		if len(n.kids) > 2 {
+			c.emit_term(n.kids[2])
+			exits = append(exits, c.emit(opc_jump_unless_none, 0))
+			c.emit(opc_mute, 0)
+			c.emit_term(terminator)
+			c.emit(opc_unmute, 0)
+			c.emit(opc_unterminated, 0)
+			c.patch(exits)
+			return
+		}
+		if t := node_of(terminator); t != nil && t.op == op_literal {
+			c.prog.literals = append(c.prog.literals, t.s)
+			pc := c.emit(opc_scan_to, 0)
+			c.prog.code[pc].min = len(c.prog.literals) - 1
+			exits = append(exits, pc)
+		}
+		loop := len(c.prog.code)
+		c.emit(opc_mute, 0)
+		c.emit_term(terminator)
+		c.emit(opc_unmute, 0)
+		exits = append(exits, c.emit(opc_jump_if_none, 0))
+		exits = append(exits, c.emit(opc_fetch_any, 0))
+		c.emit(opc_jump, loop)
+		c.patch(exits)
+
+	default:
+		c.prog.terms = append(c.prog.terms, t)
+		c.emit(opc_call, len(c.prog.terms)-1)
+	}
+}
+
+func (p *program) run(src Source, ctx *Context) ErrCode {
+	s, _ := src.(*static_impl)
+	if s != nil && s.tick() {
+		return ErrCodeUnmatched
+	}
+	if s != nil && !s.plain {
+		s = nil
+	}
+	ec := ErrCodeNone
+	for pc := 0; pc < len(p.code); {
+		in := &p.code[pc]
+		pc++
+		switch in.op {
+		case opc_class:
+			ec = p.match_class(s, src, ctx, p.classes[in.arg])
+
+		case opc_span:
+			ec = p.match_span(s, src, ctx, p.classes[in.arg], in.min)
+
+		case opc_literal:
+			ec = ErrCodeUnmatched
+			if lit := p.literals[in.arg]; src.Leap(lit) {
+				if ctx != nil {
+					ctx.WriteString(lit)
+				}
+				ec = ErrCodeNone
+			}
+
+		case opc_any_of:
+			ec = p.match_any_of(src, ctx, p.any_of[in.arg])
+
+		case opc_call:
+			ec = p.terms[in.arg](src, ctx)
+
+		case opc_jump:
+			pc = in.arg
+
+		case opc_unterminated:
+			if ec == ErrCodeUnmatched {
+				ec = ErrCodeUnterminated
+			}
+
+		case opc_fetch_any:
+			if c := src.Fetch(nil); c == Unmatched {
+				ec = ErrCodeUnterminated
+				pc = in.arg
+			} else if ctx != nil {
+				ctx.WriteRune(c)
+			}
+
+		case opc_scan_to:
+			if s != nil {
+				if scanned, ok := scan_to(s, ctx, p.literals[in.min]); ok {
+					ec = scanned
+					pc = in.arg
+				}
+			}
+
+		case opc_mute:
+			if ctx != nil {
+				ctx.muted++
+			}
+
+		case opc_unmute:
+			if ctx != nil {
+				ctx.muted--
+			}
+
+		case opc_jump_unless_none:
+			if ec != ErrCodeNone {
+				pc = in.arg
+			}
+
+		case opc_jump_if_none:
+			if ec == ErrCodeNone {
+				pc = in.arg
+			}
+
+		case opc_jump_unless_unmatched:
+			if ec != ErrCodeUnmatched {
+				pc = in.arg
+			}
+
+		case opc_sequence_next:
+			if ec == ErrCodeUnmatched {
+				ec = ErrCodeNone
+				pc = in.arg
+			} else if ec != ErrCodeNone {
+				pc = in.arg
+			}
+
+		case opc_optional:
+			if ec == ErrCodeUnmatched {
+				ec = ErrCodeNone
+			}
+
+		case opc_guard:
+			if s != nil && s.pos < s.end && s.buf[s.pos] < utf8.RuneSelf && !p.firsts[in.min][s.buf[s.pos]] {
+				ec = ErrCodeUnmatched
+				pc = in.arg
+			}
+		}
+	}
+	return ec
+}
+
+// match_class matches a single codepoint; s is the source if it allows
+// direct access to its buffer.
+func (p *program) match_class(s *static_impl, src Source, ctx *Context, cl *char_class) ErrCode {
+	if s != nil && s.pos < s.end && s.buf[s.pos] < utf8.RuneSelf {
+		b := s.buf[s.pos]
+		if cl.ascii[b] {
+			s.step_ascii(rune(b))
+			s.watched()
+			if ctx != nil {
+				ctx.WriteByte(b)
+			}
+			return ErrCodeNone
+		}
+	} else if c := src.Fetch(cl.match); c != Unmatched {
+		if ctx != nil {
+			ctx.WriteRune(c)
+		}
+		return ErrCodeNone
+	}
+	return ErrCodeUnmatched
+}
+
+// match_span matches a run of codepoints, consuming ASCII content directly
+// from the buffer of s when possible.
+func (p *program) match_span(s *static_impl, src Source, ctx *Context, cl *char_class, min int) ErrCode {
+	n := 0
+	for {
+		if s != nil {
+			start := s.pos
+			for s.pos < s.end && s.pos <= s.watch && s.buf[s.pos] < utf8.RuneSelf && cl.ascii[s.buf[s.pos]] {
+				s.step_ascii(rune(s.buf[s.pos]))
+			}
+			if s.pos > start {
+				n += s.pos - start
+				if ctx != nil {
+					ctx.Write(s.buf[start:s.pos])
+				}
+				s.watched()
+			}
+			if s.pos < s.end && s.buf[s.pos] < utf8.RuneSelf && !cl.ascii[s.buf[s.pos]] {
+				break
+			}
+		}
+		c := src.Fetch(cl.match)
+		if c == Unmatched {
+			break
+		}
+		n++
+		if ctx != nil {
+			ctx.WriteRune(c)
+		}
+	}
+	if n < min {
+		return ErrCodeUnmatched
+	}
+	return ErrCodeNone
+}
+
+func (p *program) match_any_of(src Source, ctx *Context, tab *any_table) ErrCode {
+	c := src.Peek()
+	var alts []string
+	if c < utf8.RuneSelf {
+		alts = tab.ascii[c]
+	} else if c != Unmatched {
+		alts = tab.other[c]
+	}
+	for _, alt := range alts {
+		if src.Leap(alt) {
+			if ctx != nil {
+				ctx.WriteString(alt)
+			}
+			return ErrCodeNone
+		}
+	}
+	return ErrCodeUnmatched
+}
+
+// scan_to captures the content up to the terminator and consumes it. If the
+// terminator is missing, everything up to the end of input is captured and
+// ErrCodeUnterminated is returned. Content that is not valid UTF-8 is left
+// for the codepoint by codepoint loop, which applies the invalid policy.
+func scan_to(s *static_impl, ctx *Context, terminator string) (ErrCode, bool) {
+	rest := s.buf[s.pos:s.end]
+	i := bytes.Index(rest, []byte(terminator))
+	ec := ErrCodeNone
+	if i < 0 {
+		i, ec = len(rest), ErrCodeUnterminated
+	}
+	if !utf8.Valid(rest[:i]) {
+		return 0, false
+	}
+	// consume in steps that stop at the watch offset, so that the limits
+	// apply as with the codepoint loop
+	start, end := s.pos, s.pos+i
+	for s.pos < end && s.err == nil {
+		to := end
+		if s.watch < to {
+			for to = s.watch + 1; to < end && !utf8.RuneStart(s.buf[to]); to++ {
+			}
+		}
+		s.advance_to(to)
+	}
+	if ctx != nil {
+		ctx.Write(s.buf[start:s.pos])
+	}
+	if s.err != nil {
+		return ErrCodeUnterminated, true
+	}
+	if ec == ErrCodeNone {
+		s.advance_to(s.pos + len(terminator))
+	}
+	return ec, true
+}
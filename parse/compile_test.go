@@ -0,0 +1,146 @@
+package parse
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	is_lower := func(c rune) bool { return 'a' <= c && c <= 'z' }
+	is_cyr := func(c rune) bool { return 'а' <= c && c <= 'я' }
+	terms := map[string]TermFunc{
+		"class":      FirstOf('a', is_dec, 'ж'),
+		"span":       OneOrMore(FirstOf(is_lower, is_cyr, '_')),
+		"ident":      Sequence(is_lower, ZeroOrMore(FirstOf(is_lower, is_dec))),
+		"skip":       Sequence(Skip(ZeroOrMore(' ')), OneOrMore(is_lower)),
+		"optional":   Sequence("x", Optional('-'), OneOrMore(is_dec)),
+		"first":      FirstOf("ab", Sequence('a', OneOrMore('b')), 'c'),
+		"loop":       ZeroOrMore(Sequence('a', Optional('b'))),
+		"nested":     OneOrMore(Sequence(FirstOf("12", 'x'), Skip(Optional(' ')))),
+		"opaque":     Sequence(is_lower, Uint[uint8]("", 10, 255), Between('(', ')')),
+		"incomplete": Sequence('[', OneOrMore(is_dec), Cut("']'", ']')),
+		"any_of":     OneOrMore(AnyOf("a", "ab", "ж", "жж", "[")),
+		"comment":    Between("/*", "*/"),
+		"line":       Between('[', EOL),
+		"string":     Between('[', ']', ZeroOrMore(FirstOf(is_dec, Escaped('\\', map[rune]any{'n': '\n'})))),
+	}
+	inputs := []string{
+		"", "a", "7", "ж", "z", "abc", "a1b2", "жжa", "ab_вг1", "  abc", "x-12", "x12",
+		"x-", "ab", "abbb", "c", "abab", "aab", "12x 12 x", "12 1", "q200(zz)", "q300",
+		"[12]", "[12", "[", "a\xffb", "a\nb", "жжжab[", "/* x\n */ y", "/* ж", "/*\xff*/", "/**/",
+		"[1\\n2]", "[1\\x]", "[1\n2",
+	}
+	for name, term := range terms {
+		compiled := Compile(term)
+		for _, input := range inputs {
+			run := func(f TermFunc) string {
+				lc := LineCol{}
+				src := Static([]byte(input), &lc)
+				ctx := Context{}
+				ctx.Attach(src)
+				ec := f(src, &ctx)
+				return fmt.Sprintf("%v %q %v @%d %s", ec, ctx.String(), ctx.Values, src.Offset(), &lc)
+			}
+			want, got := run(term), run(compiled)
+			if got != want {
+				t.Errorf("%s(%q): compiled = %s, want %s", name, input, got, want)
+			}
+		}
+	}
+}
+
+func TestCompileTokenize(t *testing.T) {
+	compiled := func(bb []*Binding[string]) []*Binding[string] {
+		r := make([]*Binding[string], len(bb))
+		for i, b := range bb {
+			r[i] = Bind(b.k, b.descr, Compile(b.c))
+		}
+		return r
+	}
+	tokens := func(buf []byte, bb []*Binding[string]) string {
+		s := ""
+		err := Tokenize(buf, bb, func(k string, c *Context, lc LineCol) {
+			s += fmt.Sprintf("%s:%q@%s ", k, c.String(), &lc)
+		})
+		return fmt.Sprintf("%s err=%v", s, err)
+	}
+	for _, tt := range []struct {
+		src string
+		bb  []*Binding[string]
+	}{
+		{string(bench_c()[:2000]), c_bindings()},
+		{"int x = 0x1z; @", c_bindings()},
+		{string(bench_json()[:2000]), json_bindings()},
+		{`{"a": "bA", "c": [1.5, tru]}`, json_bindings()},
+	} {
+		want, got := tokens([]byte(tt.src), tt.bb), tokens([]byte(tt.src), compiled(tt.bb))
+		if got != want {
+			t.Errorf("compiled Tokenize() = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestCompileNodes(t *testing.T) {
+	for _, tt := range []struct {
+		term TermFunc
+		op   node_op
+	}{
+		{Codepoint('a'), op_rune},
+		{CodepointFunc(is_dec), op_pred},
+		{Literal("ab"), op_literal},
+		{Sequence('a', 'b'), op_sequence},
+		{FirstOf('a', 'b'), op_first_of},
+		{Optional('a'), op_optional},
+		{ZeroOrMore('a'), op_zero_or_more},
+		{OneOrMore('a'), op_one_or_more},
+		{Skip('a'), op_skip},
+		{AnyOf("a", "b"), op_any_of},
+		{Between('[', ']'), op_between},
+		{Between('[', ']', 'a'), op_between},
+		{Escaped('\\', map[rune]any{'n': '\n'}), op_escaped},
+		{EOL, op_eol},
+		{EOF, op_eof},
+		{pass('a'), op_rune},
+	} {
+		if n := node_of(tt.term); n == nil || n.op != tt.op {
+			t.Errorf("node_of() = %v, want op %d", n, tt.op)
+		}
+	}
+	not_digit := func(src Source, ctx *Context) ErrCode {
+		if ec := Codepoint('a')(src, ctx); ec != ErrCodeNone || src.Peek() == '0' {
+			return ErrCodeUnmatched
+		}
+		return ErrCodeNone
+	}
+	recursive := Recursive(func(self TermFunc) TermFunc { return Sequence('(', Optional(self), ')') })
+	for _, term := range []TermFunc{
+		nil, LineEnd(BreakLF), Memoize('a', 'b'), Uint[uint8]("", 10, 255),
+		Cut("a", 'a'), Labeled("a", "", 'a'), recursive, not_digit,
+	} {
+		if n := node_of(term); n != nil {
+			t.Errorf("node_of() = %v, want nil", n)
+		}
+	}
+}
+
+// pass is a custom term that only calls the term of a.
+func pass(a any) TermFunc {
+	t := asTermFunc(a)
+	return func(src Source, ctx *Context) ErrCode { return t(src, ctx) }
+}
+
+func TestCompileSelfReference(t *testing.T) {
+	// the term refers to itself through a custom term, which Compile looks
+	// through
+	var list TermFunc
+	list = Sequence('(', ZeroOrMore(FirstOf('a', pass(func(src Source, ctx *Context) ErrCode {
+		return list(src, ctx)
+	}))), ')')
+	compiled := Compile(list)
+	for _, in := range []string{"()", "(a(a)(()))", "(a(", "x"} {
+		want, got := run_term(list, in), run_term(compiled, in)
+		if got != want {
+			t.Errorf("%q: compiled %s, want %s", in, got, want)
+		}
+	}
+}
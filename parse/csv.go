@@ -0,0 +1,173 @@
+package parse
+
+import "io"
+
+// CSVDialect describes a flavor of delimiter-separated values.
+type CSVDialect struct {
+	Delimiter rune // field separator
+	Quote     rune // quote character, 0 disables quoting
+	Escape    rune // escape character inside quoted fields, 0 for RFC 4180 doubled quotes
+	Lenient   bool // accept bare quotes and content that follows closing quotes
+}
+
+var (
+	// DialectCSV is the RFC 4180 dialect.
+	DialectCSV = CSVDialect{Delimiter: ',', Quote: '"'}
+
+	// DialectTSV is the tab-separated dialect without quoting.
+	DialectTSV = CSVDialect{Delimiter: '\t'}
+)
+
+// CSVField is a single field value along with the location of its first
+// codepoint (or its opening quote for quoted fields).
+type CSVField struct {
+	Text   string
+	Quoted bool
+	Loc    LineCol
+}
+
+// CSVReader reads records from a Source. The lc pointer, if not nil, must be
+// the one that the Source updates; it is used to report field locations.
+//
+// Records are read from a source created with Static or StaticWith, so the
+// whole input is loaded in memory; there is no Source that streams from an
+// io.Reader.
+type CSVReader struct {
+	src     Source
+	lc      *LineCol
+	dialect CSVDialect
+	quoted  TermFunc
+	ctx     Context
+}
+
+func NewCSVReader(src Source, lc *LineCol, d CSVDialect) *CSVReader {
+	if d.Delimiter == 0 || d.Delimiter == d.Quote || d.Delimiter == '\n' || d.Delimiter == '\r' {
+		panic("invalid csv delimiter")
+	}
+	r := &CSVReader{src: src, lc: lc, dialect: d}
+	if d.Quote != 0 {
+		q := d.Quote
+		var special TermFunc
+		if d.Escape == 0 || d.Escape == q {
+			doubled := string([]rune{q, q})
+			special = func(src Source, ctx *Context) ErrCode {
+				if !src.Leap(doubled) {
+					return ErrCodeUnmatched
+				}
+				if ctx != nil {
+					ctx.WriteRune(q)
+				}
+				return ErrCodeNone
+			}
+		} else {
+			special = Escaped(d.Escape, map[rune]any{
+				Unmatched: CodepointFunc(func(rune) bool { return true }),
+			})
+		}
+		esc := d.Escape
+		if esc == 0 {
+			esc = q
+		}
+		content := CodepointFunc(func(c rune) bool {
+			return c != q && c != esc
+		})
+		r.quoted = Between(q, q, ZeroOrMore(FirstOf(special, content)))
+	}
+	return r
+}
+
+func (r *CSVReader) loc() LineCol {
+	if r.lc == nil {
+		return LineCol{}
+	}
+	return *r.lc
+}
+
+func (r *CSVReader) fail(ec ErrCode, what string, lc LineCol) error {
+	return &ErrAtLineCol{Err: &ErrContent{Code: ec, What: what}, Loc: lc}
+}
+
+// Read returns the next record. Blank lines are skipped. It returns io.EOF
+// when the source is exhausted.
+func (r *CSVReader) Read() ([]CSVField, error) {
+	for r.eol() {
+		if r.src.Done() {
+			return nil, io.EOF
+		}
+	}
+
+	var record []CSVField
+	for {
+		field := CSVField{Loc: r.loc()}
+		r.ctx.Reset()
+
+		if r.quoted != nil && r.src.Peek() == r.dialect.Quote {
+			field.Quoted = true
+			if ec := r.quoted(r.src, &r.ctx); ec != ErrCodeNone {
+				return nil, r.fail(ec, "quoted field", field.Loc)
+			}
+			if !r.at_separator() {
+				if !r.dialect.Lenient {
+					return nil, r.fail(ErrCodeUnexpected, "content after quoted field", r.loc())
+				}
+				if err := r.unquoted(); err != nil {
+					return nil, err
+				}
+			}
+		} else if err := r.unquoted(); err != nil {
+			return nil, err
+		}
+
+		field.Text = r.ctx.String()
+		record = append(record, field)
+
+		if r.src.Hop(r.dialect.Delimiter) {
+			continue
+		}
+		r.eol()
+		return record, nil
+	}
+}
+
+// ReadAll reads all the remaining records.
+func (r *CSVReader) ReadAll() ([][]CSVField, error) {
+	var records [][]CSVField
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
+
+func (r *CSVReader) at_separator() bool {
+	switch c := r.src.Peek(); c {
+	case Unmatched, '\n', '\r', r.dialect.Delimiter:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *CSVReader) unquoted() error {
+	for {
+		switch c := r.src.Peek(); c {
+		case Unmatched, '\n', '\r', r.dialect.Delimiter:
+			return nil
+		default:
+			if c == r.dialect.Quote && c != 0 && !r.dialect.Lenient {
+				return r.fail(ErrCodeUnexpected, "quote in unquoted field", r.loc())
+			}
+			r.src.Fetch(nil)
+			r.ctx.WriteRune(c)
+		}
+	}
+}
+
+// eol consumes a record terminator: LF, CRLF, or a bare CR.
+func (r *CSVReader) eol() bool {
+	return EOL(r.src, nil) == ErrCodeNone || r.src.Hop('\r')
+}
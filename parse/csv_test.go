@@ -0,0 +1,96 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCSVReader(t *testing.T) {
+	tests := []struct {
+		dialect CSVDialect
+		src     string
+		want    string
+	}{
+		{DialectCSV, "", ""},
+		{DialectCSV, "a,b,c", "[a|b|c]"},
+		{DialectCSV, "a,b\nc,d\n", "[a|b][c|d]"},
+		{DialectCSV, "a,b\r\nc,d\r\n", "[a|b][c|d]"},
+		{DialectCSV, "a\n\n\nb", "[a][b]"},
+		{DialectCSV, "a,,", "[a||]"},
+		{DialectCSV, `"a,b",c`, "[a,b|c]"},
+		{DialectCSV, `"a""b"`, `[a"b]`},
+		{DialectCSV, "\"a\nb\",c", "[a\nb|c]"},
+		{DialectCSV, `""`, "[]"},
+		{DialectCSV, `"abc`, "<!ERR:[1:1] unterminated quoted field>"},
+		{DialectCSV, "x\n\"abc", "[x]<!ERR:[2:1] unterminated quoted field>"},
+		{DialectCSV, `"a"b`, "<!ERR:[1:4] unexpected content after quoted field>"},
+		{DialectCSV, `a"b`, "<!ERR:[1:2] unexpected quote in unquoted field>"},
+		{CSVDialect{Delimiter: ',', Quote: '"', Lenient: true}, `a"b,"c"d`, `[a"b|cd]`},
+		{CSVDialect{Delimiter: ';', Quote: '\'', Escape: '\\'}, `'a\'b';'c\\'`, `[a'b|c\]`},
+		{DialectTSV, "a\tb\n\"c\"\td", "[a|b][\"c\"|d]"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("csv %q", tt.src), func(t *testing.T) {
+			lc := LineCol{}
+			r := NewCSVReader(Static([]byte(tt.src), &lc), &lc, tt.dialect)
+			records, err := r.ReadAll()
+			got := ""
+			for _, rec := range records {
+				ss := []string{}
+				for _, f := range rec {
+					ss = append(ss, f.Text)
+				}
+				got += "[" + strings.Join(ss, "|") + "]"
+			}
+			if err != nil {
+				got += fmt.Sprintf("<!ERR:%s>", err.Error())
+			}
+			if got != tt.want {
+				t.Errorf("ReadAll() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVFieldLocations(t *testing.T) {
+	lc := LineCol{}
+	r := NewCSVReader(Static([]byte("a,\"b\nb\",c\nd"), &lc), &lc, DialectCSV)
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := ""
+	for _, rec := range records {
+		for _, f := range rec {
+			got += fmt.Sprintf("%s@%s ", f.Text, &f.Loc)
+		}
+	}
+	want := "a@1:1 b\nb@1:3 c@2:4 d@3:1 "
+	if got != want {
+		t.Errorf("locations = %q, want %q", got, want)
+	}
+}
+
+func TestCSVInterleaved(t *testing.T) {
+	// readers of the same dialect do not share any state
+	a := NewCSVReader(Static([]byte("\"a\"\"1\",x\n\"a2\""), nil), nil, DialectCSV)
+	b := NewCSVReader(Static([]byte("\"b1\"\n\"b\"\"2\",y"), nil), nil, DialectCSV)
+	got := ""
+	for i := 0; i < 2; i++ {
+		for _, r := range []*CSVReader{a, b} {
+			rec, err := r.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range rec {
+				got += f.Text + "|"
+			}
+			got += " "
+		}
+	}
+	want := `a"1|x| b1| a2| b"2|y| `
+	if got != want {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,430 @@
+package parse
+
+import (
+	"math"
+	"time"
+)
+
+// Date matches a calendar date in the `YYYY-MM-DD` format (RFC 3339
+// full-date, TOML local date), captures it, and appends the corresponding
+// time.Time value (midnight UTC) to ctx.Values.
+//
+// Returned values are:
+//
+//   - `ErrCodeUnmatched` if src does not start with a digit
+//   - `ErrCodeIncomplete` if a field or separator is missing
+//   - `ErrCodeInvalid` if the month or day is out of range
+//   - `ErrCodeNone` if src contains a valid date
+//
+// On failure, src is left right after the offending field. With sources
+// that implement Positioned, Tokenize reports out of range values at the
+// start of the field.
+func Date(src Source, ctx *Context) ErrCode {
+	y, m, d, ec := scan_date(src, ctx)
+	if ec == ErrCodeNone && ctx != nil {
+		ctx.Values = append(ctx.Values, time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC))
+	}
+	return ec
+}
+
+// Time matches a time of day in the `HH:MM:SS[.fraction]` format (RFC 3339
+// partial-time, TOML local time), captures it, and appends the corresponding
+// time.Time value (on January 1 of year 0, UTC) to ctx.Values.
+//
+// Returned values are:
+//
+//   - `ErrCodeUnmatched` if src does not start with a digit
+//   - `ErrCodeIncomplete` if a field or separator is missing
+//   - `ErrCodeInvalid` if the hour, minute or second is out of range
+//   - `ErrCodeNone` if src contains a valid time
+func Time(src Source, ctx *Context) ErrCode {
+	h, m, s, ns, ec := scan_time(src, ctx, Unmatched, mark_field(src))
+	if ec == ErrCodeNone && ctx != nil {
+		ctx.Values = append(ctx.Values, time.Date(0, 1, 1, h, m, s, ns, time.UTC))
+	}
+	return ec
+}
+
+// DateTime matches a date optionally followed by a time and a time offset:
+//
+//	1979-05-27                    local date
+//	1979-05-27T07:32:00           local date-time
+//	1979-05-27 07:32:00.999       local date-time, TOML-style separator
+//	1979-05-27T07:32:00Z          offset date-time (RFC 3339)
+//	1979-05-27T00:32:00-07:00     offset date-time (RFC 3339)
+//
+// The matched text is captured and the corresponding time.Time value is
+// appended to ctx.Values. Values without an offset are returned in UTC.
+//
+// Returned values are the same as for Date and Time, with `ErrCodeInvalid`
+// also reported for out of range offsets. An out of range hour after a space
+// separator is only reported at the start of the field with sources that
+// also implement Rewinder.
+func DateTime(src Source, ctx *Context) ErrCode {
+	y, mo, d, ec := scan_date(src, ctx)
+	if ec != ErrCodeNone {
+		return ec
+	}
+
+	lead := Unmatched
+	at := field_mark{}
+	has_time := false
+	if c := src.Fetch(func(c rune) bool { return c == 'T' || c == 't' }); c != Unmatched {
+		if ctx != nil {
+			ctx.WriteRune(c)
+		}
+		at = mark_field(src)
+		has_time = true
+	} else if lead, at = skip_time_space(src); lead != Unmatched {
+		if ctx != nil {
+			ctx.WriteByte(' ')
+		}
+		has_time = true
+	}
+	if !has_time {
+		if ctx != nil {
+			ctx.Values = append(ctx.Values, time.Date(y, time.Month(mo), d, 0, 0, 0, 0, time.UTC))
+		}
+		return ErrCodeNone
+	}
+
+	h, mi, s, ns, ec := scan_time(src, ctx, lead, at)
+	if ec == ErrCodeUnmatched {
+		return ErrCodeIncomplete
+	} else if ec != ErrCodeNone {
+		return ec
+	}
+
+	loc, ec := scan_offset(src, ctx)
+	if ec != ErrCodeNone {
+		return ec
+	}
+	if ctx != nil {
+		ctx.Values = append(ctx.Values, time.Date(y, time.Month(mo), d, h, mi, s, ns, loc))
+	}
+	return ErrCodeNone
+}
+
+// Duration matches an ISO 8601 duration such as `P1DT2H30M` or `PT0.5S`,
+// captures it, and appends the corresponding time.Duration value to
+// ctx.Values. Days are treated as 24 hours and weeks as 7 days.
+//
+// Returned values are:
+//
+//   - `ErrCodeUnmatched` if src does not start with `P`
+//   - `ErrCodeIncomplete` if no components follow `P` or `T`
+//   - `ErrCodeInvalid` if a component is malformed, out of order, or uses
+//     years or months which have no fixed duration
+//   - `ErrCodeOverflow` if the value does not fit into time.Duration
+//   - `ErrCodeNone` if src contains a valid duration
+func Duration(src Source, ctx *Context) ErrCode {
+	if !src.Hop('P') {
+		return ErrCodeUnmatched
+	}
+	if ctx != nil {
+		ctx.WriteByte('P')
+	}
+
+	const date_units = "YMWD"
+	const time_units = "HMS"
+	units := date_units
+	unit_idx := 0
+	in_time := false
+	n_components := 0
+	var total float64
+
+	for {
+		if !in_time && src.Hop('T') {
+			if ctx != nil {
+				ctx.WriteByte('T')
+			}
+			in_time = true
+			units = time_units
+			unit_idx = 0
+			n_components = 0
+			continue
+		}
+
+		at := mark_field(src)
+		v, n, has_frac := scan_decimal(src, ctx)
+		if n == 0 {
+			break
+		}
+		c := src.Fetch(nil)
+		if c == Unmatched {
+			return ErrCodeIncomplete
+		}
+		if ctx != nil {
+			ctx.WriteRune(c)
+		}
+		i := unit_idx
+		for i < len(units) && rune(units[i]) != c {
+			i++
+		}
+		if i == len(units) {
+			return at.invalid(ctx)
+		}
+		unit_idx = i + 1
+		n_components++
+
+		var scale time.Duration
+		switch {
+		case in_time && c == 'H':
+			scale = time.Hour
+		case in_time && c == 'M':
+			scale = time.Minute
+		case in_time && c == 'S':
+			scale = time.Second
+		case c == 'W':
+			scale = 7 * 24 * time.Hour
+		case c == 'D':
+			scale = 24 * time.Hour
+		default:
+			// years and months
+			return at.invalid(ctx)
+		}
+		if has_frac && !(in_time && c == 'S') {
+			return at.invalid(ctx)
+		}
+		total += v * float64(scale)
+		if total > math.MaxInt64 {
+			return ErrCodeOverflow
+		}
+		if c == 'S' {
+			break
+		}
+	}
+
+	if n_components == 0 {
+		return ErrCodeIncomplete
+	}
+	if ctx != nil {
+		ctx.Values = append(ctx.Values, time.Duration(math.Round(total)))
+	}
+	return ErrCodeNone
+}
+
+// field_mark is the position of a field, at which out of range values are
+// reported.
+type field_mark struct {
+	at Location
+	lc LineCol
+	ok bool
+}
+
+func mark_field(src Source) field_mark {
+	if p, ok := src.(Positioned); ok {
+		return field_mark{at: p.Location(), lc: p.LineCol(), ok: true}
+	}
+	return field_mark{}
+}
+
+// invalid records the position as the location of the failure, unless one is
+// recorded already, and returns ErrCodeInvalid.
+func (m field_mark) invalid(ctx *Context) ErrCode {
+	if ctx != nil && m.ok && !ctx.failure.has_loc {
+		ctx.failure.at = m.at
+		ctx.failure.loc = m.lc
+		ctx.failure.has_loc = true
+	}
+	return ErrCodeInvalid
+}
+
+func scan_fixed(src Source, ctx *Context, n_digits int, lead rune) (int, ErrCode) {
+	v, n := 0, 0
+	if lead != Unmatched {
+		v, n = int(dec(lead)), 1
+		if ctx != nil {
+			ctx.WriteRune(lead)
+		}
+	}
+	for ; n < n_digits; n++ {
+		c := src.Fetch(is_dec)
+		if c == Unmatched {
+			if n == 0 {
+				return 0, ErrCodeUnmatched
+			}
+			return 0, ErrCodeIncomplete
+		}
+		if ctx != nil {
+			ctx.WriteRune(c)
+		}
+		v = v*10 + int(dec(c))
+	}
+	return v, ErrCodeNone
+}
+
+func scan_sep(src Source, ctx *Context, sep rune) ErrCode {
+	if !src.Hop(sep) {
+		return ErrCodeIncomplete
+	}
+	if ctx != nil {
+		ctx.WriteRune(sep)
+	}
+	return ErrCodeNone
+}
+
+func scan_field(src Source, ctx *Context, sep rune, min, max int) (int, ErrCode) {
+	if ec := scan_sep(src, ctx, sep); ec != ErrCodeNone {
+		return 0, ec
+	}
+	at := mark_field(src)
+	v, ec := scan_fixed(src, ctx, 2, Unmatched)
+	if ec == ErrCodeUnmatched {
+		return 0, ErrCodeIncomplete
+	} else if ec != ErrCodeNone {
+		return 0, ec
+	} else if v < min || v > max {
+		return 0, at.invalid(ctx)
+	}
+	return v, ErrCodeNone
+}
+
+func scan_date(src Source, ctx *Context) (y, m, d int, ec ErrCode) {
+	if y, ec = scan_fixed(src, ctx, 4, Unmatched); ec != ErrCodeNone {
+		return
+	}
+	if m, ec = scan_field(src, ctx, '-', 1, 12); ec != ErrCodeNone {
+		return
+	}
+	days := time.Date(y, time.Month(m)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	d, ec = scan_field(src, ctx, '-', 1, days)
+	return
+}
+
+// skip_time_space consumes a space followed by a digit, the separator of a
+// TOML-style date-time, and returns the digit along with the position of the
+// hour. The position is only recorded if src is a Rewinder, as the space has
+// to be consumed on its own, see Rewinder.
+func skip_time_space(src Source) (rune, field_mark) {
+	rw, ok := src.(Rewinder)
+	if !ok {
+		return src.Skip(" ", is_dec), field_mark{}
+	}
+	mark := rw.Mark()
+	if !src.Hop(' ') {
+		return Unmatched, field_mark{}
+	}
+	at := mark_field(src)
+	lead := src.Fetch(is_dec)
+	if lead == Unmatched {
+		rw.Rewind(mark)
+	}
+	return lead, at
+}
+
+// scan_time scans a time, with the first digit of the hour already consumed
+// if lead is not Unmatched; at is the position of the hour.
+func scan_time(src Source, ctx *Context, lead rune, at field_mark) (h, m, s, ns int, ec ErrCode) {
+	if h, ec = scan_fixed(src, ctx, 2, lead); ec != ErrCodeNone {
+		return
+	} else if h > 23 {
+		ec = at.invalid(ctx)
+		return
+	}
+	if m, ec = scan_field(src, ctx, ':', 0, 59); ec != ErrCodeNone {
+		return
+	}
+	if s, ec = scan_field(src, ctx, ':', 0, 59); ec != ErrCodeNone {
+		return
+	}
+	if src.Hop('.') {
+		if ctx != nil {
+			ctx.WriteByte('.')
+		}
+		n_digits := 0
+		for {
+			c := src.Fetch(is_dec)
+			if c == Unmatched {
+				break
+			}
+			if ctx != nil {
+				ctx.WriteRune(c)
+			}
+			// digits beyond nanosecond precision are truncated
+			if n_digits < 9 {
+				ns = ns*10 + int(dec(c))
+				n_digits++
+			}
+		}
+		if n_digits == 0 {
+			ec = ErrCodeIncomplete
+			return
+		}
+		for i := n_digits; i < 9; i++ {
+			ns *= 10
+		}
+	}
+	return
+}
+
+func scan_offset(src Source, ctx *Context) (*time.Location, ErrCode) {
+	c := src.Fetch(func(c rune) bool { return c == 'Z' || c == 'z' || c == '+' || c == '-' })
+	if c == Unmatched {
+		return time.UTC, ErrCodeNone
+	}
+	if ctx != nil {
+		ctx.WriteRune(c)
+	}
+	if c == 'Z' || c == 'z' {
+		return time.UTC, ErrCodeNone
+	}
+	at := mark_field(src)
+	h, ec := scan_fixed(src, ctx, 2, Unmatched)
+	if ec == ErrCodeUnmatched {
+		return nil, ErrCodeIncomplete
+	} else if ec != ErrCodeNone {
+		return nil, ec
+	} else if h > 23 {
+		return nil, at.invalid(ctx)
+	}
+	m, ec := scan_field(src, ctx, ':', 0, 59)
+	if ec != ErrCodeNone {
+		return nil, ec
+	}
+	offset := (h*60 + m) * 60
+	if c == '-' {
+		offset = -offset
+	}
+	return time.FixedZone("", offset), ErrCodeNone
+}
+
+// scan_decimal captures a decimal number with an optional fraction that uses
+// either `.` or `,` as a separator (both are allowed by ISO 8601).
+func scan_decimal(src Source, ctx *Context) (v float64, n_digits int, has_frac bool) {
+	for {
+		c := src.Fetch(is_dec)
+		if c == Unmatched {
+			break
+		}
+		if ctx != nil {
+			ctx.WriteRune(c)
+		}
+		v = v*10 + float64(dec(c))
+		n_digits++
+	}
+	if n_digits == 0 {
+		return
+	}
+	sep := src.Fetch(func(c rune) bool { return c == '.' || c == ',' })
+	if sep == Unmatched {
+		return
+	}
+	if ctx != nil {
+		ctx.WriteRune(sep)
+	}
+	has_frac = true
+	scale := 0.1
+	for {
+		c := src.Fetch(is_dec)
+		if c == Unmatched {
+			break
+		}
+		if ctx != nil {
+			ctx.WriteRune(c)
+		}
+		v += float64(dec(c)) * scale
+		scale /= 10
+	}
+	return
+}
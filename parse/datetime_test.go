@@ -0,0 +1,167 @@
+package parse
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// run_term matches term at the start of input and describes the outcome:
+// the error code, the captured text, the values and the offset at which the
+// source is left.
+func run_term(term TermFunc, input string) string {
+	return run_term_with(term, input, func(src Source) Source { return src })
+}
+
+// run_term_with runs term on a static source wrapped by wrap.
+func run_term_with(term TermFunc, input string, wrap func(Source) Source) string {
+	src := Static([]byte(input), nil)
+	ctx := Context{}
+	ec := term(wrap(src), &ctx)
+	values := ""
+	for _, v := range ctx.Values {
+		switch v := v.(type) {
+		case time.Time:
+			values += " " + v.Format(time.RFC3339Nano)
+		default:
+			values += fmt.Sprintf(" %v", v)
+		}
+	}
+	name := ec.String()
+	switch ec {
+	case ErrCodeNone:
+		name = "ok"
+	case ErrCodeUnmatched:
+		name = "unmatched"
+	}
+	return fmt.Sprintf("%s %q%s @%d", name, ctx.String(), values, src.Offset())
+}
+
+func TestDateTime(t *testing.T) {
+	tests := []struct {
+		term  TermFunc
+		input string
+		want  string
+	}{
+		{Date, "1979-05-27", `ok "1979-05-27" 1979-05-27T00:00:00Z @10`},
+		{Date, "2024-02-29x", `ok "2024-02-29" 2024-02-29T00:00:00Z @10`},
+		{Date, "2000-02-29", `ok "2000-02-29" 2000-02-29T00:00:00Z @10`},
+		{Date, "1900-02-29", `invalid "1900-02-29" @10`},
+		{Date, "2023-02-29", `invalid "2023-02-29" @10`},
+		{Date, "2023-04-31", `invalid "2023-04-31" @10`},
+		{Date, "2023-12-31", `ok "2023-12-31" 2023-12-31T00:00:00Z @10`},
+		{Date, "2023-13-01", `invalid "2023-13" @7`},
+		{Date, "2023-00-01", `invalid "2023-00" @7`},
+		{Date, "2023-01-00", `invalid "2023-01-00" @10`},
+		{Date, "2023-1-01", `incomplete "2023-1" @6`},
+		{Date, "2023", `incomplete "2023" @4`},
+		{Date, "2023-", `incomplete "2023-" @5`},
+		{Date, "x", `unmatched "" @0`},
+
+		{Time, "07:32:00", `ok "07:32:00" 0000-01-01T07:32:00Z @8`},
+		{Time, "23:59:59.999999", `ok "23:59:59.999999" 0000-01-01T23:59:59.999999Z @15`},
+		{Time, "00:00:00.1234567891", `ok "00:00:00.1234567891" 0000-01-01T00:00:00.123456789Z @19`},
+		{Time, "00:00:00.", `incomplete "00:00:00." @9`},
+		{Time, "24:00:00", `invalid "24" @2`},
+		{Time, "12:60:00", `invalid "12:60" @5`},
+		{Time, "12:00:60", `invalid "12:00:60" @8`},
+		{Time, "12:00", `incomplete "12:00" @5`},
+
+		{DateTime, "1979-05-27", `ok "1979-05-27" 1979-05-27T00:00:00Z @10`},
+		{DateTime, "1979-05-27 x", `ok "1979-05-27" 1979-05-27T00:00:00Z @10`},
+		{DateTime, "1979-05-27T07:32:00", `ok "1979-05-27T07:32:00" 1979-05-27T07:32:00Z @19`},
+		{DateTime, "1979-05-27 07:32:00.999", `ok "1979-05-27 07:32:00.999" 1979-05-27T07:32:00.999Z @23`},
+		{DateTime, "1979-05-27t07:32:00z", `ok "1979-05-27t07:32:00z" 1979-05-27T07:32:00Z @20`},
+		{DateTime, "1979-05-27T00:32:00-07:00", `ok "1979-05-27T00:32:00-07:00" 1979-05-27T00:32:00-07:00 @25`},
+		{DateTime, "1979-05-27T00:32:00+05:30", `ok "1979-05-27T00:32:00+05:30" 1979-05-27T00:32:00+05:30 @25`},
+		{DateTime, "1979-05-27T00:32:00+24:00", `invalid "1979-05-27T00:32:00+24" @22`},
+		{DateTime, "1979-05-27T00:32:00+05:60", `invalid "1979-05-27T00:32:00+05:60" @25`},
+		{DateTime, "1979-05-27T00:32:00+05", `incomplete "1979-05-27T00:32:00+05" @22`},
+		{DateTime, "1979-05-27T", `incomplete "1979-05-27T" @11`},
+		{DateTime, "1979-05-27 25:00:00", `invalid "1979-05-27 25" @13`},
+
+		{Duration, "P1D", `ok "P1D" 24h0m0s @3`},
+		{Duration, "P1W", `ok "P1W" 168h0m0s @3`},
+		{Duration, "P1DT2H30M", `ok "P1DT2H30M" 26h30m0s @9`},
+		{Duration, "PT0.5S", `ok "PT0.5S" 500ms @6`},
+		{Duration, "PT1,5S", `ok "PT1,5S" 1.5s @6`},
+		{Duration, "PT1H1H", `invalid "PT1H1H" @6`},
+		{Duration, "PT1M1H", `invalid "PT1M1H" @6`},
+		{Duration, "P1M", `invalid "P1M" @3`},
+		{Duration, "P1.5D", `invalid "P1.5D" @5`},
+		{Duration, "P", `incomplete "P" @1`},
+		{Duration, "PT", `incomplete "PT" @2`},
+		{Duration, "P1", `incomplete "P1" @2`},
+		{Duration, "P999999999999D", `overflow "P999999999999D" @14`},
+		{Duration, "1D", `unmatched "" @0`},
+	}
+	for _, tt := range tests {
+		if got := run_term(tt.term, tt.input); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDateTimeErrorLocation(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"x 2023-13-01", "[1:8] invalid timestamp"},
+		{"2023-02-30", "[1:9] invalid timestamp"},
+		{"x\n2023-02-03T24:00:00", "[2:12] invalid timestamp"},
+		{"2023-02-03 24:00:00", "[1:12] invalid timestamp"},
+		{"2023-02-03T12:00:00+05:61", "[1:24] invalid timestamp"},
+		{"2023-02-03T12:00:00+25:00", "[1:21] invalid timestamp"},
+		{"P1DT1M1H", "[1:7] invalid timestamp"},
+		{"2023-02-03T12:00", "[1:1] incomplete timestamp"},
+	}
+	bb := []*Binding[string]{
+		Bind("ws", "whitespace", Skip(OneOrMore(is_ws))),
+		Bind("time", "timestamp", FirstOf(DateTime, Duration)),
+		Bind("id", "ident", OneOrMore(is_alpha)),
+	}
+	for _, tt := range tests {
+		err := Tokenize([]byte(tt.src), bb, func(k string, c *Context, lc LineCol) {})
+		if fmt.Sprint(err) != tt.want {
+			t.Errorf("Tokenize(%q) = %v, want %s", tt.src, err, tt.want)
+		}
+	}
+
+	// UTF-16 input, the hour follows a space
+	src := StaticWith([]byte("2\x000\x002\x003\x00-\x000\x002\x00-\x000\x003\x00 \x002\x004\x00"), nil, &Options{Encoding: EncodingUTF16LE})
+	ctx := Context{}
+	if ec := DateTime(src, &ctx); ec != ErrCodeInvalid || ctx.failure.at.Offset != 22 || ctx.failure.loc.ColumnIndex != 11 {
+		t.Errorf("DateTime() = %v at %+v, want invalid at offset 22, column 11", ec, ctx.failure)
+	}
+
+	// visual columns, the hour follows a space after a wide character
+	src = StaticWith([]byte("名 2023-02-03 24:00:00"), nil, &Options{Columns: ColumnVisual})
+	src.Hop('名')
+	src.Hop(' ')
+	ctx = Context{}
+	if ec := DateTime(src, &ctx); ec != ErrCodeInvalid || ctx.failure.at.Offset != 15 || ctx.failure.loc.ColumnIndex != 14 {
+		t.Errorf("DateTime() = %v at %+v, want invalid at offset 15, column 14", ec, ctx.failure)
+	}
+
+	// without Rewinder, the space and the digit are consumed together
+	ctx = Context{}
+	if ec := DateTime(new_positioned("2023-02-03 24:00:00"), &ctx); ec != ErrCodeInvalid || ctx.failure.has_loc {
+		t.Errorf("DateTime() = %v at %+v, want invalid without a location", ec, ctx.failure)
+	}
+	ctx = Context{}
+	if ec := DateTime(new_positioned("2023-02-03 12:00:00"), &ctx); ec != ErrCodeNone || ctx.String() != "2023-02-03 12:00:00" {
+		t.Errorf("DateTime() = %v, %q", ec, ctx.String())
+	}
+}
+
+// positioned is a source that reports its position but cannot rewind.
+type positioned struct {
+	Source
+	Positioned
+}
+
+func new_positioned(input string) positioned {
+	src := Static([]byte(input), nil)
+	return positioned{src, src}
+}
@@ -0,0 +1,312 @@
+package parse
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type Severity int
+
+const (
+	SeverityError = Severity(iota)
+	SeverityWarning
+	SeverityNote
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	default:
+		return "<unknown>"
+	}
+}
+
+// Label marks a span of source content with an optional short message.
+type Label struct {
+	Loc  LineCol
+	Len  int // in codepoints, at least one cell is always marked
+	Text string
+
+	// Span holds the byte offsets of the marked content, if known. It takes
+	// precedence over Loc and Len for placing the marker, so that the
+	// columns of Loc may be counted in any ColumnMode.
+	Span Span
+}
+
+// Diagnostic is a compiler-style message attached to source content. The
+// first label, if any, is the primary one; the rest are secondary.
+type Diagnostic struct {
+	Severity Severity
+	Message  string
+	Hint     string
+	File     string
+	Labels   []Label
+}
+
+func (d *Diagnostic) Error() string {
+	if len(d.Labels) == 0 {
+		return d.Message
+	}
+	return fmt.Sprintf("[%s] %s", &d.Labels[0].Loc, d.Message)
+}
+
+// DiagnosticOf converts err into a Diagnostic. Errors that carry no location
+// produce a diagnostic without labels.
+func DiagnosticOf(err error) *Diagnostic {
+	var d *Diagnostic
+	if errors.As(err, &d) {
+		return d
+	}
+	var e *ErrAtLineCol
+	if errors.As(err, &e) {
+		d = &Diagnostic{
+			Message: e.Err.Error(),
+			File:    e.File,
+			Labels:  []Label{{Loc: e.Loc, Len: 1, Span: e.Span}},
+		}
+		if c, ok := e.Err.(*ErrContent); ok && c.Hint != "" {
+			unhinted := *c
+			unhinted.Hint = ""
+			d.Message, d.Hint = unhinted.Error(), c.Hint
+		}
+		return d
+	}
+	return &Diagnostic{Message: err.Error()}
+}
+
+type RenderOptions struct {
+	Color    bool // use ANSI escape sequences
+	TabWidth int  // tab stop width, defaults to 4
+}
+
+// RenderError writes a diagnostic for err with the relevant lines of buf,
+// enabling colors when w is a terminal.
+func RenderError(w io.Writer, buf []byte, err error) error {
+	return DiagnosticOf(err).Render(w, buf, &RenderOptions{Color: is_terminal(w)})
+}
+
+const (
+	ansi_reset  = "\x1b[0m"
+	ansi_bold   = "\x1b[1m"
+	ansi_red    = "\x1b[1;31m"
+	ansi_yellow = "\x1b[1;33m"
+	ansi_cyan   = "\x1b[1;36m"
+	ansi_blue   = "\x1b[1;34m"
+)
+
+// Render writes the diagnostic to w, quoting the labeled lines of buf:
+//
+//	error: unterminated string
+//	 --> main.txt:2:7
+//	  |
+//	2 | say: 'hello
+//	  |      ^ started here
+//	  = help: add a closing quote
+//
+// Tabs are expanded and wide characters are accounted for, so that markers
+// line up with the content when displayed in a terminal.
+func (d *Diagnostic) Render(w io.Writer, buf []byte, opts *RenderOptions) error {
+	o := RenderOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.TabWidth <= 0 {
+		o.TabWidth = 4
+	}
+	paint := func(color, s string) string {
+		if !o.Color || s == "" {
+			return s
+		}
+		return color + s + ansi_reset
+	}
+	severity_color := ansi_red
+	switch d.Severity {
+	case SeverityWarning:
+		severity_color = ansi_yellow
+	case SeverityNote:
+		severity_color = ansi_cyan
+	}
+
+	out := strings.Builder{}
+	out.WriteString(paint(severity_color, d.Severity.String()))
+	out.WriteString(paint(ansi_bold, ": "+d.Message))
+	out.WriteByte('\n')
+
+	pad := " "
+	if len(d.Labels) > 0 {
+		index := NewLineIndex(buf)
+		marks := make([]label_mark, len(d.Labels))
+		lines := []int{}
+		by_line := map[int][]int{}
+		max_line := 0
+		for i, l := range d.Labels {
+			m := mark_label(l, buf, index)
+			marks[i] = m
+			if _, ok := by_line[m.line]; !ok {
+				lines = append(lines, m.line)
+			}
+			by_line[m.line] = append(by_line[m.line], i)
+			if m.line > max_line {
+				max_line = m.line
+			}
+		}
+		sort.Ints(lines)
+		pad = strings.Repeat(" ", len(strconv.Itoa(max_line+1)))
+		gutter := paint(ansi_blue, pad+" |")
+
+		loc := d.Labels[0].Loc.String()
+		if d.File != "" {
+			loc = d.File + ":" + loc
+		}
+		fmt.Fprintf(&out, "%s %s\n%s\n", paint(ansi_blue, pad+"-->"), loc, gutter)
+
+		write_line := func(line_idx int, text string) {
+			num := strconv.Itoa(line_idx + 1)
+			fmt.Fprintf(&out, "%s %s\n",
+				paint(ansi_blue, strings.Repeat(" ", len(pad)-len(num))+num+" |"),
+				expand_tabs(text, o.TabWidth))
+		}
+
+		for i, line_idx := range lines {
+			if i > 0 && line_idx == lines[i-1]+2 {
+				// a single line gap is shown rather than elided
+				write_line(line_idx-1, index.LineText(line_idx-1))
+			} else if i > 0 && line_idx > lines[i-1]+2 {
+				out.WriteString(paint(ansi_blue, "..."))
+				out.WriteByte('\n')
+			}
+			text := index.LineText(line_idx)
+			cells := display_cells(text, o.TabWidth)
+			write_line(line_idx, text)
+
+			for _, label_idx := range by_line[line_idx] {
+				l, m := d.Labels[label_idx], marks[label_idx]
+				first := m.first
+				if first > len(cells)-1 {
+					first = len(cells) - 1
+				}
+				last := first + m.n
+				if last > len(cells)-1 {
+					last = len(cells) - 1
+				}
+				width := cells[last] - cells[first]
+				if width < 1 {
+					width = 1
+				}
+				marker, color := "^", severity_color
+				if label_idx > 0 {
+					marker, color = "-", ansi_blue
+				}
+				fmt.Fprintf(&out, "%s %s%s", gutter,
+					strings.Repeat(" ", cells[first]),
+					paint(color, strings.Repeat(marker, width)))
+				if l.Text != "" {
+					out.WriteString(" " + paint(color, l.Text))
+				}
+				out.WriteByte('\n')
+			}
+		}
+	}
+
+	if d.Hint != "" {
+		fmt.Fprintf(&out, "%s %s\n", paint(ansi_blue, pad+" ="), paint(ansi_bold, "help:")+" "+d.Hint)
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// label_mark is the line of a label and the codepoints that it marks.
+type label_mark struct {
+	line  int
+	first int
+	n     int
+}
+
+// mark_label locates the content marked by l, from its span if it is set
+// and within buf, or from its location and length otherwise.
+func mark_label(l Label, buf []byte, index *LineIndex) label_mark {
+	if s := l.Span; s != (Span{}) && s.Start.Offset <= s.End.Offset && s.End.Offset <= len(buf) {
+		lc := index.LineCol(s.Start.Offset)
+		end := s.End.Offset
+		if _, line_end := index.LineRange(lc.LineIndex); end > line_end {
+			end = line_end
+		}
+		m := label_mark{line: lc.LineIndex, first: lc.ColumnIndex}
+		if end > s.Start.Offset {
+			m.n = utf8.RuneCount(buf[s.Start.Offset:end])
+		}
+		return m
+	}
+	m := label_mark{line: l.Loc.LineIndex, first: l.Loc.ColumnIndex, n: l.Len}
+	if m.line < 0 {
+		m.line = 0
+	}
+	if m.first < 0 {
+		m.first = 0
+	}
+	if m.n < 0 {
+		m.n = 0
+	}
+	return m
+}
+
+// display_cells returns the display column at which each codepoint of s
+// starts; the extra last element is the display width of the whole line.
+func display_cells(s string, tab_width int) []int {
+	cells := make([]int, 0, utf8.RuneCountInString(s)+1)
+	col := 0
+	for _, c := range s {
+		cells = append(cells, col)
+		if c == '\t' {
+			col += tab_width - col%tab_width
+		} else {
+			col += rune_width(c)
+		}
+	}
+	return append(cells, col)
+}
+
+func expand_tabs(s string, tab_width int) string {
+	if strings.IndexByte(s, '\t') < 0 {
+		return s
+	}
+	b := strings.Builder{}
+	col := 0
+	for _, c := range s {
+		if c == '\t' {
+			n := tab_width - col%tab_width
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+		} else {
+			b.WriteRune(c)
+			col += rune_width(c)
+		}
+	}
+	return b.String()
+}
+
+// is_terminal reports whether w is a character device and colors are not
+// disabled with the NO_COLOR environment variable.
+func is_terminal(w io.Writer) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,148 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiagnosticRender(t *testing.T) {
+	buf := []byte("x = 1\n\ty = 'abc\n名前 = z\n")
+
+	tests := []struct {
+		name string
+		d    *Diagnostic
+		want string
+	}{
+		{"no labels", &Diagnostic{Message: "oops"}, `
+error: oops
+`},
+		{"tab", &Diagnostic{
+			Message: "unterminated string",
+			File:    "a.txt",
+			Labels:  []Label{{Loc: LineCol{1, 5}, Len: 4, Text: "starts here"}},
+		}, `
+error: unterminated string
+ --> a.txt:2:6
+  |
+2 |     y = 'abc
+  |         ^^^^ starts here
+`},
+		{"wide", &Diagnostic{
+			Severity: SeverityWarning,
+			Message:  "undefined",
+			Labels: []Label{
+				{Loc: LineCol{2, 5}, Len: 1},
+				{Loc: LineCol{2, 0}, Len: 2, Text: "assigned"},
+				{Loc: LineCol{0, 0}, Len: 1, Text: "first"},
+			},
+		}, `
+warning: undefined
+ --> 3:6
+  |
+1 | x = 1
+  | - first
+2 |     y = 'abc
+3 | 名前 = z
+  |        ^
+  | ---- assigned
+`},
+		{"hint", &Diagnostic{
+			Message: "unterminated string",
+			Hint:    "add a closing quote",
+			Labels:  []Label{{Loc: LineCol{1, 5}, Len: 1}},
+		}, `
+error: unterminated string
+ --> 2:6
+  |
+2 |     y = 'abc
+  |         ^
+  = help: add a closing quote
+`},
+		{"negative length", &Diagnostic{
+			Message: "oops",
+			Labels:  []Label{{Loc: LineCol{0, 2}, Len: -3}},
+		}, `
+error: oops
+ --> 1:3
+  |
+1 | x = 1
+  |   ^
+`},
+		{"span", &Diagnostic{
+			Message: "unterminated string",
+			Labels: []Label{{Loc: LineCol{1, 11}, Len: 1, Span: Span{
+				Start: Location{Offset: 11, LineNumber: 2, LineOffset: 6},
+				End:   Location{Offset: 15, LineNumber: 2, LineOffset: 6},
+			}}},
+		}, `
+error: unterminated string
+ --> 2:12
+  |
+2 |     y = 'abc
+  |         ^^^^
+`},
+		{"past end", &Diagnostic{
+			Message: "unexpected end",
+			Labels:  []Label{{Loc: LineCol{0, 10}, Len: 3}},
+		}, `
+error: unexpected end
+ --> 1:11
+  |
+1 | x = 1
+  |      ^
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := strings.Builder{}
+			if err := tt.d.Render(&b, buf, nil); err != nil {
+				t.Fatal(err)
+			}
+			want := strings.TrimPrefix(tt.want, "\n")
+			if b.String() != want {
+				t.Errorf("Render() =\n%s\nwant\n%s", b.String(), want)
+			}
+		})
+	}
+}
+
+func TestDiagnosticOf(t *testing.T) {
+	err := Tokenize([]byte("a;"), []*Binding[int]{Bind(0, "a", 'a')}, func(int, *Context, LineCol) {})
+	d := DiagnosticOf(err)
+	b := strings.Builder{}
+	d.Render(&b, []byte("a;"), &RenderOptions{Color: true})
+	want := "\x1b[1;31merror\x1b[0m\x1b[1m: expected a\x1b[0m\n" +
+		"\x1b[1;34m -->\x1b[0m 1:2\n" +
+		"\x1b[1;34m  |\x1b[0m\n" +
+		"\x1b[1;34m1 |\x1b[0m a;\n" +
+		"\x1b[1;34m  |\x1b[0m  \x1b[1;31m^\x1b[0m\n"
+	if b.String() != want {
+		t.Errorf("Render() = %q, want %q", b.String(), want)
+	}
+}
+
+func TestRenderErrorColumns(t *testing.T) {
+	bb := []*Binding[string]{
+		Bind("ws", "whitespace", Skip(OneOrMore(is_ws))),
+		Bind("word", "word", OneOrMore(func(c rune) bool { return c > ' ' && c != ';' })),
+	}
+	for _, opts := range []*Options{
+		nil,
+		{Columns: ColumnVisual, TabWidth: 4},
+		{Columns: ColumnUTF16},
+		{Columns: ColumnBytes},
+	} {
+		for _, src := range []string{"\tab ;", "\t\U0001F600\u540d ;"} {
+			err := TokenizeWith([]byte(src), bb, func(string, *Context, LineCol) {}, opts)
+			b := strings.Builder{}
+			RenderError(&b, []byte(src), err)
+			lines := strings.Split(b.String(), "\n")
+			// the caret is below the semicolon
+			text, marker := lines[3], lines[4]
+			cells := display_cells(text[:strings.IndexByte(text, ';')], 4)
+			if strings.IndexByte(marker, '^') != cells[len(cells)-1] {
+				t.Errorf("%+v: %q rendered as\n%s", opts, src, b.String())
+			}
+		}
+	}
+}
@@ -2,29 +2,96 @@ package parse
 
 import "fmt"
 
+// ErrContent describes what went wrong. It matches sentinel errors such as
+// ErrInvalid with errors.Is; the optional Cause is exposed via Unwrap.
 type ErrContent struct {
-	Code ErrCode
-	What string
+	Code  ErrCode
+	What  string
+	Hint  string // optional suggestion for fixing the problem
+	Cause error
 }
 
 func (e *ErrContent) Error() string {
-	return e.Code.String() + " " + e.What
+	s := e.Code.String()
+	if e.What != "" {
+		s += " " + e.What
+	}
+	if e.Cause != nil {
+		s += ": " + e.Cause.Error()
+	}
+	if e.Hint != "" {
+		s += " (" + e.Hint + ")"
+	}
+	return s
+}
+
+func (e *ErrContent) Unwrap() error {
+	return e.Cause
 }
 
+// Is reports whether target is an *ErrContent with the same code and, unless
+// the target's What is empty, with the same description.
+func (e *ErrContent) Is(target error) bool {
+	t, ok := target.(*ErrContent)
+	return ok && t.Code == e.Code && (t.What == "" || t.What == e.What)
+}
+
+// Sentinel errors for use with errors.Is, each matches any ErrContent with
+// the corresponding code.
+var (
+	ErrUnexpected   = &ErrContent{Code: ErrCodeUnexpected}
+	ErrExpected     = &ErrContent{Code: ErrCodeExpected}
+	ErrUnterminated = &ErrContent{Code: ErrCodeUnterminated}
+	ErrIncomplete   = &ErrContent{Code: ErrCodeIncomplete}
+	ErrUnpaired     = &ErrContent{Code: ErrCodeUnpaired}
+	ErrInvalid      = &ErrContent{Code: ErrCodeInvalid}
+	ErrOverflow     = &ErrContent{Code: ErrCodeOverflow}
+	ErrExceeded     = &ErrContent{Code: ErrCodeExceeded}
+)
+
+// Errors for the exceeded Limits.
+var (
+	ErrTokenLength = Exceeded("token length limit")
+	ErrTokenCount  = Exceeded("token count limit")
+	ErrDepth       = Exceeded("nesting depth limit")
+)
+
+// ErrAtLineCol attaches a location and, optionally, a file name to an error.
+// Span, if known, covers the content related to the error, such as the
+// portion of the failed token.
 type ErrAtLineCol struct {
-	Err error
-	Loc LineCol
+	Err  error
+	Loc  LineCol
+	Span Span
+	File string
 }
 
 func (e *ErrAtLineCol) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("[%s:%s] %s", e.File, &e.Loc, e.Err.Error())
+	}
 	return fmt.Sprintf("[%s] %s", &e.Loc, e.Err.Error())
 }
 
-func Expected(v string) *ErrContent     { return &ErrContent{ErrCodeExpected, v} }
-func Unexpected(v string) *ErrContent   { return &ErrContent{ErrCodeUnexpected, v} }
-func Unterminated(v string) *ErrContent { return &ErrContent{ErrCodeUnterminated, v} }
-func Unpaired(v string) *ErrContent     { return &ErrContent{ErrCodeUnpaired, v} }
-func Invalid(v string) *ErrContent      { return &ErrContent{ErrCodeInvalid, v} }
+func (e *ErrAtLineCol) Unwrap() error {
+	return e.Err
+}
+
+// WithFile returns err with the file name attached to its location. If err
+// does not carry a location, it is returned as is.
+func WithFile(err error, file string) error {
+	if e, ok := err.(*ErrAtLineCol); ok {
+		return &ErrAtLineCol{Err: e.Err, Loc: e.Loc, Span: e.Span, File: file}
+	}
+	return err
+}
+
+func Expected(v string) *ErrContent     { return &ErrContent{Code: ErrCodeExpected, What: v} }
+func Unexpected(v string) *ErrContent   { return &ErrContent{Code: ErrCodeUnexpected, What: v} }
+func Unterminated(v string) *ErrContent { return &ErrContent{Code: ErrCodeUnterminated, What: v} }
+func Unpaired(v string) *ErrContent     { return &ErrContent{Code: ErrCodeUnpaired, What: v} }
+func Invalid(v string) *ErrContent      { return &ErrContent{Code: ErrCodeInvalid, What: v} }
+func Exceeded(v string) *ErrContent     { return &ErrContent{Code: ErrCodeExceeded, What: v} }
 
 type ErrCode int
 
@@ -37,6 +104,7 @@ const (
 	ErrCodeUnpaired
 	ErrCodeInvalid
 	ErrCodeOverflow
+	ErrCodeExceeded
 
 	ErrCodeUnmatched = ErrCode(-1)
 )
@@ -59,6 +127,8 @@ func (ec ErrCode) String() string {
 		return "invalid"
 	case ErrCodeOverflow:
 		return "overflow"
+	case ErrCodeExceeded:
+		return "exceeded"
 	default:
 		return "<unknown>"
 	}
@@ -0,0 +1,63 @@
+package parse
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrorsIsAs(t *testing.T) {
+	bb := []*Binding[int]{
+		Bind(0, "string", Between('\'', '\'')),
+	}
+	err := Tokenize([]byte("'abc"), bb, func(int, *Context, LineCol) {})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !errors.Is(err, ErrUnterminated) {
+		t.Errorf("errors.Is(%v, ErrUnterminated) = false", err)
+	}
+	if !errors.Is(err, Unterminated("string")) {
+		t.Errorf("errors.Is(%v, Unterminated(string)) = false", err)
+	}
+	if errors.Is(err, ErrInvalid) || errors.Is(err, Unterminated("comment")) {
+		t.Errorf("errors.Is(%v) matches unrelated errors", err)
+	}
+	var ec *ErrContent
+	if !errors.As(err, &ec) || ec.Code != ErrCodeUnterminated || ec.What != "string" {
+		t.Errorf("errors.As(%v, *ErrContent) = %v", err, ec)
+	}
+
+	err = WithFile(err, "a.txt")
+	if got, want := err.Error(), "[a.txt:1:1] unterminated string"; got != want {
+		t.Errorf("WithFile() = %q, want %q", got, want)
+	}
+	if !errors.Is(err, ErrUnterminated) {
+		t.Errorf("errors.Is(%v, ErrUnterminated) = false", err)
+	}
+
+	cause := errors.New("boom")
+	err = &ErrAtLineCol{Err: &ErrContent{Code: ErrCodeInvalid, What: "value", Cause: cause}}
+	if !errors.Is(err, cause) || !errors.Is(err, ErrInvalid) {
+		t.Errorf("errors.Is(%v) does not match cause and code", err)
+	}
+	if got, want := err.Error(), "[1:1] invalid value: boom"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestStaticInvalidUTF8(t *testing.T) {
+	lc := LineCol{}
+	src := StaticWith([]byte("ab\n c\xffd"), &lc, &Options{Invalid: InvalidError})
+	for src.Fetch(nil) != Unmatched {
+	}
+	if !src.Done() {
+		t.Error("source is not exhausted after failure")
+	}
+	err := src.Err()
+	if !errors.Is(err, ErrInvalid) {
+		t.Fatalf("Err() = %v, want invalid utf-8 sequence", err)
+	}
+	if got, want := err.Error(), "[2:3] invalid utf-8 sequence"; got != want {
+		t.Errorf("Err() = %q, want %q", got, want)
+	}
+}
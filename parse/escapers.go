@@ -38,8 +38,13 @@ func Escaped(prefix rune, escapers map[rune]any) TermFunc {
 		}
 	}
 
+	n := &node{op: op_escaped, r: prefix, escapes: literals, functors: functors}
 	return func(src Source, ctx *Context) ErrCode {
-		if !src.Hop(prefix) {
+		if d, ok := src.(*describe); ok {
+			return d.reply(n)
+		}
+		literals, functors := n.escapes, n.functors
+		if !src.Hop(n.r) {
 			return ErrCodeUnmatched
 		}
 		c := src.Peek()
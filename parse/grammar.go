@@ -0,0 +1,203 @@
+package parse
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WriteGrammar writes bindings as the PEG text read by cmd/parsegen,
+// preceded by a package clause if pkg is not empty. This allows parsegen to
+// take a grammar declared in Go from a registration file that it runs with
+// go run:
+//
+//	//go:build ignore
+//
+//	package main
+//
+//	var bindings = []*parse.Binding[string]{
+//		parse.Bind("ws", "whitespace", parse.Skip(parse.OneOrMore(unicode.IsSpace))),
+//		parse.Bind("ident", "identifier", unicode.IsLetter, parse.ZeroOrMore(unicode.IsLetter)),
+//	}
+//
+//	func main() {
+//		if err := parse.WriteGrammar(os.Stdout, "lexer", bindings); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+//
+// The keys of the bindings, formatted with fmt.Sprint, must be identifiers
+// and become the rule names. The terms must be built with Codepoint,
+// CodepointFunc, Literal, Sequence, FirstOf, Optional, ZeroOrMore,
+// OneOrMore, Skip, Between with a prefix, EOL and EOF; any other term is
+// reported as an error. Predicates are evaluated for every codepoint to
+// find their character class, so they must be pure functions of their
+// argument.
+func WriteGrammar[K Key](w io.Writer, pkg string, bindings []*Binding[K]) error {
+	b := strings.Builder{}
+	if pkg != "" {
+		fmt.Fprintf(&b, "package %s\n\n", pkg)
+	}
+	for _, binding := range bindings {
+		name := fmt.Sprint(binding.k)
+		if !is_grammar_ident(name) {
+			return fmt.Errorf("rule name %q is not an identifier", name)
+		}
+		e, err := grammar_expr(binding.c, true)
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", name, err)
+		}
+		fmt.Fprintf(&b, "%s %s <- %s\n", name, strconv.Quote(binding.descr), e)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var (
+	eol_node = &node{op: op_eol}
+	eof_node = &node{op: op_eof}
+)
+
+// grammar_expr returns the PEG expression of t. Sequences and alternatives
+// are parenthesized unless top is set.
+func grammar_expr(t TermFunc, top bool) (string, error) {
+	n := node_of(t)
+	if n == nil {
+		return "", fmt.Errorf("term not supported by the grammar")
+	}
+	group := func(s string) string {
+		if top {
+			return s
+		}
+		return "(" + s + ")"
+	}
+	switch n.op {
+	case op_rune:
+		if !utf8.ValidRune(n.r) {
+			return "", fmt.Errorf("invalid codepoint %U", n.r)
+		}
+		return strconv.Quote(string(n.r)), nil
+	case op_literal:
+		return strconv.Quote(n.s), nil
+	case op_pred:
+		return grammar_class(n.pred)
+	case op_sequence, op_first_of:
+		sep := " "
+		if n.op == op_first_of {
+			sep = " / "
+		}
+		ss := make([]string, len(n.kids))
+		for i, k := range n.kids {
+			s, err := grammar_expr(k, false)
+			if err != nil {
+				return "", err
+			}
+			ss[i] = s
+		}
+		return group(strings.Join(ss, sep)), nil
+	case op_optional, op_zero_or_more, op_one_or_more:
+		s, err := grammar_expr(n.kids[0], false)
+		if err != nil {
+			return "", err
+		}
+		return s + map[node_op]string{op_optional: "?", op_zero_or_more: "*", op_one_or_more: "+"}[n.op], nil
+	case op_skip, op_between:
+		ss := make([]string, len(n.kids))
+		for i, k := range n.kids {
+			s, err := grammar_expr(k, true)
+			if err != nil {
+				return "", err
+			}
+			ss[i] = s
+		}
+		if n.op == op_skip {
+			return "skip(" + ss[0] + ")", nil
+		}
+		return "between(" + strings.Join(ss, ", ") + ")", nil
+	case op_eol:
+		return "eol", nil
+	case op_eof:
+		return "eof", nil
+	}
+	return "", fmt.Errorf("term not supported by the grammar")
+}
+
+// grammar_class returns the character class of the codepoints that pred
+// accepts, negated if that is shorter, or . if it accepts all of them.
+func grammar_class(pred func(rune) bool) (string, error) {
+	var in, out []rune_span
+	ok := false
+	for r := rune(0); r <= unicode.MaxRune; r++ {
+		// surrogates are never decoded, they go with the codepoint before
+		if r < surrogate_min || r > surrogate_max {
+			ok = pred(r)
+		}
+		spans := &out
+		if ok {
+			spans = &in
+		}
+		if k := len(*spans); k > 0 && (*spans)[k-1].hi == r-1 {
+			(*spans)[k-1].hi = r
+		} else {
+			*spans = append(*spans, rune_span{r, r})
+		}
+	}
+	switch {
+	case len(in) == 0:
+		return "", fmt.Errorf("predicate does not match any codepoint")
+	case len(out) == 0:
+		return ".", nil
+	}
+	b := strings.Builder{}
+	b.WriteByte('[')
+	spans := in
+	if len(out) < len(in) {
+		b.WriteByte('^')
+		spans = out
+	}
+	for _, s := range spans {
+		b.WriteString(class_char(s.lo))
+		hi := s.hi
+		if hi >= surrogate_min && hi <= surrogate_max {
+			hi = surrogate_min - 1
+		}
+		if hi > s.lo+1 {
+			b.WriteByte('-')
+		}
+		if hi > s.lo {
+			b.WriteString(class_char(hi))
+		}
+	}
+	b.WriteByte(']')
+	return b.String(), nil
+}
+
+const (
+	surrogate_min = 0xd800
+	surrogate_max = 0xdfff
+)
+
+type rune_span struct {
+	lo, hi rune
+}
+
+// class_char escapes c for a character class.
+func class_char(c rune) string {
+	if strings.ContainsRune(`]-^'"`, c) {
+		return `\` + string(c)
+	}
+	q := strconv.QuoteRuneToASCII(c)
+	return q[1 : len(q)-1]
+}
+
+func is_grammar_ident(s string) bool {
+	for i, c := range s {
+		if c != '_' && !unicode.IsLetter(c) && (i == 0 || !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,50 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestWriteGrammar(t *testing.T) {
+	is_digit := func(c rune) bool { return '0' <= c && c <= '9' }
+	tests := []struct {
+		key  any
+		term any
+		want string
+	}{
+		{"ws", Skip(OneOrMore(' ')), `ws "d" <- skip(" "+)`},
+		{"num", Sequence(OneOrMore(is_digit), Optional(Sequence('.', OneOrMore(is_digit)))), `num "d" <- [0-9]+ ("." [0-9]+)?`},
+		{"op", FirstOf("**", '*', Sequence('+', '+')), `op "d" <- "**" / "*" / ("+" "+")`},
+		{"line", Between("#", EOL), `line "d" <- between("#", eol)`},
+		{"end", Sequence('.', EOF), `end "d" <- "." eof`},
+		{"str", Between('"', '"', ZeroOrMore(func(c rune) bool { return c != '"' && c != '\\' })), `str "d" <- between("\"", "\"", [^\"\\]*)`},
+		{"any", func(rune) bool { return true }, `any "d" <- .`},
+		{"esc", func(c rune) bool { return strings.ContainsRune("]-^\n", c) }, `esc "d" <- [\n\-\]\^]`},
+		{"tail", func(c rune) bool { return c > 0xd7ff }, `tail "d" <- [\ue000-\U0010ffff]`},
+		{"head", func(c rune) bool { return c < 0xe000 }, `head "d" <- [\x00-\ud7ff]`},
+		{"name", unicode.IsUpper, ""},
+		{"ab", AnyOf("a", "b"), "rule ab: term not supported by the grammar"},
+		{"nil", Between(nil, ']'), "rule nil: term not supported by the grammar"},
+		{"none", func(rune) bool { return false }, "rule none: predicate does not match any codepoint"},
+		{"a-b", 'a', `rule name "a-b" is not an identifier`},
+		{1, 'a', `rule name "1" is not an identifier`},
+	}
+	for _, tt := range tests {
+		b := strings.Builder{}
+		err := WriteGrammar(&b, "", []*Binding[any]{Bind(tt.key, "d", tt.term)})
+		got := strings.TrimSuffix(b.String(), "\n")
+		if err != nil {
+			got = err.Error()
+		}
+		if tt.want != "" && got != tt.want {
+			t.Errorf("WriteGrammar(%v) = %s, want %s", tt.key, got, tt.want)
+		} else if tt.want == "" && err != nil {
+			t.Errorf("WriteGrammar(%v) error = %v", tt.key, err)
+		}
+	}
+	b := strings.Builder{}
+	if err := WriteGrammar(&b, "lexer", []*Binding[string]{Bind("a", "", 'a')}); err != nil || b.String() != "package lexer\n\na \"\" <- \"a\"\n" {
+		t.Errorf("WriteGrammar() = %q, %v", b.String(), err)
+	}
+}
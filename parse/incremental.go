@@ -0,0 +1,154 @@
+package parse
+
+import "sort"
+
+// Token is a token recorded by TokenizeAll and Retokenize.
+type Token[T Key] struct {
+	Key    T
+	Text   string // the captured content
+	Values []any
+	Span   Span
+	Loc    LineCol // the location of the start, as passed to on_token
+}
+
+// TokenizeAll is the same as TokenizeWith, but returns the tokens. On error,
+// the tokens matched before the error are returned along with it.
+func TokenizeAll[T Key](buf []byte, bindings []*Binding[T], opts *Options) ([]Token[T], error) {
+	var tokens []Token[T]
+	err := TokenizeWith(buf, bindings, func(k T, c *Context, lc LineCol) {
+		tokens = append(tokens, make_token(k, c, lc))
+	}, opts)
+	return tokens, err
+}
+
+func make_token[T Key](k T, c *Context, lc LineCol) Token[T] {
+	t := Token[T]{Key: k, Text: c.String(), Span: c.Span, Loc: lc}
+	if len(c.Values) > 0 {
+		t.Values = append([]any(nil), c.Values...)
+	}
+	return t
+}
+
+// Edit describes a change of the content: Deleted bytes at Offset are
+// replaced by Inserted.
+type Edit struct {
+	Offset   int
+	Deleted  int
+	Inserted string
+}
+
+// Apply returns the content of buf after the edit. Like append, it updates
+// buf in place if its capacity allows.
+func (e Edit) Apply(buf []byte) []byte {
+	n := len(buf) + len(e.Inserted) - e.Deleted
+	if n > cap(buf) {
+		r := make([]byte, 0, n)
+		r = append(r, buf[:e.Offset]...)
+		r = append(r, e.Inserted...)
+		return append(r, buf[e.Offset+e.Deleted:]...)
+	}
+	r := buf[:n]
+	copy(r[e.Offset+len(e.Inserted):], buf[e.Offset+e.Deleted:])
+	copy(r[e.Offset:], e.Inserted)
+	return r
+}
+
+// Retokenize updates the tokens of the content prior to the edit, as
+// returned by TokenizeAll or an earlier Retokenize, for buf, the content
+// after the edit. It is meant for editors that tokenize the content on every
+// change:
+//
+//	buf = edit.Apply(buf)
+//	tokens, err = parse.Retokenize(buf, tokens, edit, bindings, opts)
+//
+// The content is tokenized from the start of the token preceding the one
+// touched by the edit, so that tokens that looked at the content right after
+// their end are matched again, and stops as soon as a token starts at the
+// same place as one of the previous tokens after the edit. As the matching of
+// a token only depends on the content that follows its start, the rest of
+// the previous tokens are kept with their locations shifted. With
+// ColumnVisual, the token must also start at the same column, as tab stops
+// do not shift with the content. A
+// token that spans the edit, such as a multi-line comment, is matched again
+// as a whole, and an edit that opens such a token makes Retokenize continue
+// until the token ends.
+//
+// The options must be the same as for the previous tokens, except for
+// LineIndex, which is ignored. On error, the tokens matched before the error
+// are returned along with it.
+func Retokenize[T Key](buf []byte, prev []Token[T], edit Edit, bindings []*Binding[T], opts *Options) ([]Token[T], error) {
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	o.LineIndex = nil
+	src := StaticWith(buf, nil, &o)
+
+	// restart at the token before the first one that ends at or after the
+	// edit
+	r := sort.Search(len(prev), func(i int) bool {
+		return prev[i].Span.End.Offset >= edit.Offset
+	})
+	if r > 0 {
+		r--
+	}
+	if r < len(prev) {
+		src.seek(prev[r].Span.Start, prev[r].Loc)
+	}
+
+	delta := len(edit.Inserted) - edit.Deleted
+	edit_end := edit.Offset + len(edit.Inserted) // in buf
+	tokens := append([]Token[T](nil), prev[:r]...)
+	next := r // the previous token to synchronize with
+	if len(prev) == 0 || prev[len(prev)-1].Span.End.Offset+delta != len(buf) {
+		// the previous tokenization failed, there is nothing to keep
+		next = len(prev)
+	}
+	synced, at_new, lc_new := -1, Location{}, LineCol{}
+	err := tokenize(src, bindings, func(k T, c *Context, lc LineCol) {
+		at := c.Span.Start.Offset
+		if at > edit_end {
+			// the tokens ahead of the edit cannot match any more
+			for next < len(prev) && prev[next].Span.Start.Offset+delta < at {
+				next++
+			}
+			// with visual columns, the tokens that follow on the same line
+			// are only at the same columns if this one is
+			if next < len(prev) && prev[next].Span.Start.Offset+delta == at &&
+				(src.columns != ColumnVisual || prev[next].Loc.ColumnIndex == lc.ColumnIndex) {
+				synced, at_new, lc_new = next, c.Span.Start, lc
+				src.end = src.pos // stops tokenize
+				return
+			}
+		}
+		tokens = append(tokens, make_token(k, c, lc))
+	})
+	if synced < 0 {
+		return tokens, err
+	}
+
+	// the rest are the previous tokens, starting with the one identical to
+	// the token at which tokenize stopped
+	at_old, lc_old := prev[synced].Span.Start, prev[synced].Loc
+	lines := at_new.LineNumber - at_old.LineNumber
+	columns := lc_new.ColumnIndex - lc_old.ColumnIndex
+	shift := func(l Location) Location {
+		if l.LineNumber == at_old.LineNumber {
+			l.LineOffset = at_new.LineOffset
+		} else {
+			l.LineOffset += delta
+		}
+		l.Offset += delta
+		l.LineNumber += lines
+		return l
+	}
+	for _, t := range prev[synced:] {
+		t.Span = Span{shift(t.Span.Start), shift(t.Span.End)}
+		if t.Loc.LineIndex == lc_old.LineIndex {
+			t.Loc.ColumnIndex += columns
+		}
+		t.Loc.LineIndex += lines
+		tokens = append(tokens, t)
+	}
+	return tokens, nil
+}
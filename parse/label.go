@@ -0,0 +1,76 @@
+package parse
+
+// Labeled wraps content so that its failures are described by what instead
+// of the description of the enclosing binding, optionally accompanied by a
+// hint. For example:
+//
+//	Labeled("string literal", "did you forget a closing quote?",
+//		Between('"', '"', ZeroOrMore(str_content)))
+//
+// makes Tokenize report `unterminated string literal (did you forget a
+// closing quote?)`. When content does not match, what is used in the
+// expected set instead of the expectations of the inner terms.
+//
+// Labels may be nested, the innermost one takes precedence.
+func Labeled(what, hint string, content ...any) TermFunc {
+	v := Sequence(content...)
+	return func(src Source, ctx *Context) ErrCode {
+		if ctx == nil {
+			return v(src, nil)
+		}
+		p, positioned := src.(Positioned)
+		at, lc, n := Location{}, LineCol{}, 0
+		if positioned && ctx.track != nil {
+			at, lc = p.Location(), p.LineCol()
+			n = ctx.track.mark(at.Offset)
+		}
+
+		ec := v(src, ctx)
+		switch ec {
+		case ErrCodeNone:
+		case ErrCodeUnmatched:
+			if positioned && ctx.track != nil {
+				ctx.track.replace(at, lc, n, what)
+			}
+		default:
+			if ctx.failure.what == "" {
+				ctx.failure.what = what
+			}
+			if ctx.failure.hint == "" {
+				ctx.failure.hint = hint
+			}
+		}
+		return ec
+	}
+}
+
+// Cut marks a commit point: once the preceding terms have matched, content
+// is required. If content does not match, Cut fails with `ErrCodeExpected`
+// described by what and located at the position where content was expected:
+//
+//	Sequence("if", ws, Cut("condition", expr), Cut("'then'", "then"))
+//
+// Cut does not backtrack, it only converts `ErrCodeUnmatched` into a
+// descriptive error.
+func Cut(what string, content ...any) TermFunc {
+	v := Sequence(content...)
+	return func(src Source, ctx *Context) ErrCode {
+		var at Location
+		var lc LineCol
+		p, positioned := src.(Positioned)
+		if positioned {
+			at, lc = p.Location(), p.LineCol()
+		}
+		ec := v(src, ctx)
+		if ec != ErrCodeUnmatched {
+			return ec
+		}
+		if ctx != nil && ctx.failure.what == "" {
+			ctx.failure.what = what
+			ctx.failure.at = at
+			ctx.failure.loc = lc
+			ctx.failure.has_loc = positioned
+		}
+		return ErrCodeExpected
+	}
+}
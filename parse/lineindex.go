@@ -0,0 +1,126 @@
+package parse
+
+import (
+	"bytes"
+	"sort"
+	"unicode/utf8"
+)
+
+// LineIndex maps byte offsets within a UTF-8 buffer to line and column
+// numbers in O(log n) time.
+//
+// An index is either built at once with NewLineIndex, or filled
+// incrementally by a source configured with Options.LineIndex, in which case
+// it honors the source's line breaks and only covers the content consumed so
+// far.
+type LineIndex struct {
+	buf    []byte
+	starts []int // offsets at which lines start, starts[0] == 0
+}
+
+// NewLineIndex builds an index of LF-terminated lines in buf.
+func NewLineIndex(buf []byte) *LineIndex {
+	li := &LineIndex{}
+	li.reset(buf)
+	li.starts = make([]int, 1, 1+bytes.Count(buf, []byte{'\n'}))
+	for i := 0; ; {
+		n := bytes.IndexByte(buf[i:], '\n')
+		if n < 0 {
+			break
+		}
+		i += n + 1
+		li.starts = append(li.starts, i)
+	}
+	return li
+}
+
+func (li *LineIndex) reset(buf []byte) {
+	li.buf = buf
+	li.starts = append(li.starts[:0], 0)
+}
+
+// add registers the start of a line, offsets must be increasing.
+func (li *LineIndex) add(offset int) {
+	if offset > li.starts[len(li.starts)-1] {
+		li.starts = append(li.starts, offset)
+	}
+}
+
+// LineCount returns the number of lines in the index.
+func (li *LineIndex) LineCount() int {
+	return len(li.starts)
+}
+
+// Line returns the 0-based index of the line that contains offset.
+func (li *LineIndex) Line(offset int) int {
+	if offset < 0 {
+		return 0
+	}
+	return sort.Search(len(li.starts), func(i int) bool { return li.starts[i] > offset }) - 1
+}
+
+// LineCol converts offset to a location with the column counted in
+// codepoints.
+func (li *LineIndex) LineCol(offset int) LineCol {
+	line := li.Line(offset)
+	start := li.starts[line]
+	if offset > len(li.buf) {
+		offset = len(li.buf)
+	}
+	return LineCol{LineIndex: line, ColumnIndex: utf8.RuneCount(li.buf[start:offset])}
+}
+
+// Location converts offset to a Location.
+func (li *LineIndex) Location(offset int) Location {
+	line := li.Line(offset)
+	return Location{Offset: offset, LineNumber: line + 1, LineOffset: li.starts[line]}
+}
+
+// Offset converts a location with the column counted in codepoints back to a
+// byte offset. Columns past the end of the line are clipped.
+func (li *LineIndex) Offset(lc LineCol) int {
+	if lc.LineIndex < 0 {
+		return 0
+	}
+	if lc.LineIndex >= len(li.starts) {
+		return len(li.buf)
+	}
+	start, end := li.LineRange(lc.LineIndex)
+	offset := start
+	for n := 0; n < lc.ColumnIndex && offset < end; n++ {
+		_, size := utf8.DecodeRune(li.buf[offset:end])
+		offset += size
+	}
+	return offset
+}
+
+// LineRange returns the offsets of the first byte of the line and of its
+// terminator (or the end of the buffer for the last line).
+func (li *LineIndex) LineRange(line int) (start, end int) {
+	if line < 0 || line >= len(li.starts) {
+		return len(li.buf), len(li.buf)
+	}
+	start = li.starts[line]
+	end = len(li.buf)
+	if line+1 < len(li.starts) {
+		end = li.starts[line+1]
+		// strip the terminator
+		switch {
+		case end-start >= 2 && li.buf[end-2] == '\r' && li.buf[end-1] == '\n':
+			end -= 2
+		case end-start >= 1 && (li.buf[end-1] == '\n' || li.buf[end-1] == '\r'):
+			end--
+		default:
+			if c, size := utf8.DecodeLastRune(li.buf[start:end]); c == 0x85 || c == 0x2028 || c == 0x2029 {
+				end -= size
+			}
+		}
+	}
+	return start, end
+}
+
+// LineText returns the content of the line without its terminator.
+func (li *LineIndex) LineText(line int) string {
+	start, end := li.LineRange(line)
+	return string(li.buf[start:end])
+}
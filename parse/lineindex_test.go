@@ -0,0 +1,56 @@
+package parse
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLineIndex(t *testing.T) {
+	buf := []byte("ab\r\nцdef\n\nxyz")
+	li := NewLineIndex(buf)
+	if li.LineCount() != 4 {
+		t.Fatalf("LineCount() = %d, want 4", li.LineCount())
+	}
+	for _, tt := range []struct {
+		offset int
+		want   string
+	}{
+		{0, "1:1"}, {1, "1:2"}, {2, "1:3"}, {4, "2:1"}, {6, "2:2"}, {9, "2:5"}, {10, "3:1"}, {11, "4:1"}, {14, "4:4"},
+	} {
+		lc := li.LineCol(tt.offset)
+		if got := lc.String(); got != tt.want {
+			t.Errorf("LineCol(%d) = %s, want %s", tt.offset, got, tt.want)
+		}
+		if got := li.Offset(lc); got != tt.offset {
+			t.Errorf("Offset(%s) = %d, want %d", &lc, got, tt.offset)
+		}
+	}
+	got := ""
+	for i := 0; i < li.LineCount(); i++ {
+		start, end := li.LineRange(i)
+		got += fmt.Sprintf("[%d:%d %q]", start, end, li.LineText(i))
+	}
+	if want := `[0:2 "ab"][4:9 "цdef"][10:10 ""][11:14 "xyz"]`; got != want {
+		t.Errorf("lines = %s, want %s", got, want)
+	}
+}
+
+func TestLineIndexIncremental(t *testing.T) {
+	buf := []byte("a\rb\r\nc d")
+	li := &LineIndex{}
+	bb := []*Binding[int]{Bind(0, "any", OneOrMore(func(rune) bool { return true }))}
+	err := TokenizeWith(buf, bb, func(int, *Context, LineCol) {}, &Options{LineBreaks: BreaksUnicode, LineIndex: li})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := ""
+	for i := 0; i < li.LineCount(); i++ {
+		got += fmt.Sprintf("%q ", li.LineText(i))
+	}
+	if want := `"a" "b" "c" "d" `; got != want {
+		t.Errorf("lines = %s, want %s", got, want)
+	}
+	if lc := li.LineCol(len(buf)); lc.String() != "4:2" {
+		t.Errorf("LineCol(end) = %s, want 4:2", &lc)
+	}
+}
@@ -1,7 +1,12 @@
 package parse
 
-import "fmt"
+import (
+	"fmt"
+	"unicode/utf8"
+)
 
+// LineCol is a 0-based location. The units of ColumnIndex depend on the
+// ColumnMode the source is configured with, codepoints by default.
 type LineCol struct {
 	LineIndex   int // 0-based
 	ColumnIndex int // 0-based
@@ -11,12 +16,103 @@ func (lc *LineCol) String() string {
 	return fmt.Sprintf("%d:%d", lc.LineIndex+1, lc.ColumnIndex+1)
 }
 
+// Compare returns -1, 0, or +1 depending on whether lc is before, at, or
+// after other.
+func (lc *LineCol) Compare(other LineCol) int {
+	switch {
+	case lc.LineIndex != other.LineIndex:
+		return cmp_int(lc.LineIndex, other.LineIndex)
+	default:
+		return cmp_int(lc.ColumnIndex, other.ColumnIndex)
+	}
+}
+
+// Location is a byte-based position within the input. Unlike LineCol, it is
+// independent of the column mode and can be converted to a LineCol with the
+// original content or with a LineIndex.
 type Location struct {
-	Offset     int
-	LineNumber int
-	LineOffset int
+	Offset     int // 0-based byte offset
+	LineNumber int // 1-based
+	LineOffset int // byte offset of the line start
+}
+
+func (l *Location) String() string {
+	return fmt.Sprintf("%d:%d", l.LineNumber, l.ColumnNumber())
 }
 
+// ColumnNumber returns the 1-based column counted in bytes.
 func (l *Location) ColumnNumber() int {
-	return 1 + l.LineOffset - l.LineNumber
+	return 1 + l.Offset - l.LineOffset
+}
+
+// LineCol converts l to a LineCol with the column counted in codepoints of
+// the UTF-8 content in buf.
+func (l *Location) LineCol(buf []byte) LineCol {
+	start, end := l.LineOffset, l.Offset
+	if end > len(buf) {
+		end = len(buf)
+	}
+	if start > end {
+		start = end
+	}
+	return LineCol{LineIndex: l.LineNumber - 1, ColumnIndex: utf8.RuneCount(buf[start:end])}
+}
+
+// Compare returns -1, 0, or +1 depending on whether l is before, at, or
+// after other.
+func (l *Location) Compare(other Location) int {
+	return cmp_int(l.Offset, other.Offset)
+}
+
+func cmp_int(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return +1
+	default:
+		return 0
+	}
+}
+
+// Span is a range of content from Start (inclusive) to End (exclusive).
+type Span struct {
+	Start Location
+	End   Location
+}
+
+func (s *Span) String() string {
+	return s.Start.String() + "-" + s.End.String()
+}
+
+// Len returns the length of the span in bytes.
+func (s *Span) Len() int {
+	return s.End.Offset - s.Start.Offset
+}
+
+// Contains reports whether l is within the span.
+func (s *Span) Contains(l Location) bool {
+	return s.Start.Offset <= l.Offset && l.Offset < s.End.Offset
+}
+
+// Overlaps reports whether the spans share any content.
+func (s *Span) Overlaps(other Span) bool {
+	return s.Start.Offset < other.End.Offset && other.Start.Offset < s.End.Offset
+}
+
+// Merge returns the smallest span that covers both spans.
+func (s *Span) Merge(other Span) Span {
+	r := *s
+	if other.Start.Offset < r.Start.Offset {
+		r.Start = other.Start
+	}
+	if other.End.Offset > r.End.Offset {
+		r.End = other.End
+	}
+	return r
+}
+
+// Text returns the spanned content of buf.
+func (s *Span) Text(buf []byte) []byte {
+	return buf[s.Start.Offset:s.End.Offset]
 }
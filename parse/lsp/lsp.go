@@ -0,0 +1,247 @@
+// Package lsp provides semantic highlighting for the Language Server
+// Protocol from the bindings of the parse package.
+//
+// A Legend maps the binding keys to semantic token types and modifiers, and
+// Encode converts the tokens to the relative format of the protocol. Server
+// keeps the open documents tokenized and answers the
+// textDocument/semanticTokens/full and textDocument/semanticTokens/full/delta
+// requests over JSON-RPC:
+//
+//	legend := lsp.NewLegend(map[string]lsp.Style{
+//		"comment": {Type: "comment"},
+//		"keyword": {Type: "keyword"},
+//		"const":   {Type: "variable", Modifiers: []string{"readonly"}},
+//	})
+//	srv := lsp.NewServer(bindings, legend, nil)
+//	err := srv.Serve(os.Stdin, os.Stdout)
+package lsp
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/adnsv/go-parse/parse"
+)
+
+// Style is the semantic token type and modifiers of a binding key. The
+// protocol predefines types such as "comment", "keyword", "string",
+// "number", "operator", "variable" or "function", and modifiers such as
+// "declaration", "readonly" or "deprecated", but clients may support others.
+type Style struct {
+	Type      string
+	Modifiers []string
+}
+
+// Legend maps the binding keys to the semantic token types and modifiers.
+// The tokens of the keys without a style, such as whitespace, are not
+// reported.
+type Legend[T comparable] struct {
+	types     []string
+	modifiers []string
+	styles    map[T]style
+}
+
+// style is a Style encoded for the legend.
+type style struct {
+	typ       uint32 // index in types
+	modifiers uint32 // bit set of indices in modifiers
+}
+
+// NewLegend creates a legend for the styles, with the types and modifiers
+// in alphabetical order.
+func NewLegend[T comparable](styles map[T]Style) *Legend[T] {
+	l := &Legend[T]{styles: make(map[T]style, len(styles))}
+	types, modifiers := map[string]uint32{}, map[string]uint32{}
+	for _, s := range styles {
+		types[s.Type] = 0
+		for _, m := range s.Modifiers {
+			modifiers[m] = 0
+		}
+	}
+	l.types = sorted_keys(types)
+	l.modifiers = sorted_keys(modifiers)
+	if len(l.modifiers) > 32 {
+		panic("too many semantic token modifiers")
+	}
+	for k, s := range styles {
+		st := style{typ: types[s.Type]}
+		for _, m := range s.Modifiers {
+			st.modifiers |= 1 << modifiers[m]
+		}
+		l.styles[k] = st
+	}
+	return l
+}
+
+// sorted_keys returns the keys of m in order and sets their values to their
+// indices.
+func sorted_keys(m map[string]uint32) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		m[k] = uint32(i)
+	}
+	return keys
+}
+
+// Types returns the token types of the legend, as announced to the client.
+func (l *Legend[T]) Types() []string {
+	return l.types
+}
+
+// Modifiers returns the token modifiers of the legend, as announced to the
+// client.
+func (l *Legend[T]) Modifiers() []string {
+	return l.modifiers
+}
+
+// Encode returns the semantic tokens in the relative format of the protocol:
+// five integers per token, which are the line delta, the start column delta,
+// the length, the type index and the modifier bits. The columns and lengths
+// are counted in UTF-16 code units, and tokens spanning several lines are
+// split into one token per line.
+//
+// The tokens must be the tokens of the UTF-8 content buf, in order. Only
+// their spans are used, so the lines of the protocol, which end with LF, CR
+// or CRLF, do not depend on the options the content is tokenized with.
+func (l *Legend[T]) Encode(buf []byte, tokens []parse.Token[T]) []uint32 {
+	data := []uint32{}
+	c := cursor{buf: buf}
+	prev_line, prev_col := 0, 0
+	emit := func(line, col, length int, st style) {
+		if length == 0 {
+			return
+		}
+		delta := col
+		if line == prev_line {
+			delta = col - prev_col
+		}
+		data = append(data, uint32(line-prev_line), uint32(delta), uint32(length), st.typ, st.modifiers)
+		prev_line, prev_col = line, col
+	}
+	for i := range tokens {
+		t := &tokens[i]
+		st, ok := l.styles[t.Key]
+		if !ok {
+			continue
+		}
+		c.advance(t.Span.Start.Offset)
+		end := t.Span.End.Offset
+		if end > len(buf) {
+			end = len(buf)
+		}
+		for c.offset < end {
+			line, col := c.line, c.col
+			c.advance_line(end)
+			emit(line, col, c.col-col, st)
+			c.advance_break(end)
+		}
+	}
+	return data
+}
+
+// cursor tracks the position in the content as the protocol counts it.
+type cursor struct {
+	buf      []byte
+	offset   int
+	line     int
+	col      int // in UTF-16 code units
+	after_cr bool
+}
+
+// advance moves the cursor forward to the offset.
+func (c *cursor) advance(offset int) {
+	for c.offset < offset {
+		c.advance_line(offset)
+		c.advance_break(offset)
+	}
+}
+
+// advance_line moves the cursor forward to the offset or to the next line
+// break, whichever comes first.
+func (c *cursor) advance_line(offset int) {
+	for c.offset < offset {
+		b := c.buf[c.offset]
+		if b == '\n' || b == '\r' {
+			return
+		}
+		c.after_cr = false
+		if b < utf8.RuneSelf {
+			c.offset++
+			c.col++
+			continue
+		}
+		r, size := utf8.DecodeRune(c.buf[c.offset:])
+		c.offset += size
+		if r >= 0x10000 {
+			c.col += 2
+		} else {
+			c.col++
+		}
+	}
+}
+
+// advance_break moves the cursor over a line break at its offset, if any.
+func (c *cursor) advance_break(offset int) {
+	if c.offset >= offset {
+		return
+	}
+	switch c.buf[c.offset] {
+	case '\r':
+		c.line++
+		c.col = 0
+		c.after_cr = true
+	case '\n':
+		if !c.after_cr {
+			c.line++
+			c.col = 0
+		}
+		c.after_cr = false
+	default:
+		return
+	}
+	c.offset++
+}
+
+// Position is a position in a document, with the character counted in
+// UTF-16 code units.
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Offset returns the byte offset of the position in buf. Positions past the
+// end of a line refer to the end of the line, and positions past the end of
+// buf to the end of buf.
+func Offset(buf []byte, pos Position) int {
+	c := cursor{buf: buf}
+	for c.line < pos.Line && c.offset < len(buf) {
+		c.advance_line(len(buf))
+		c.advance_break(len(buf))
+	}
+	if c.line < pos.Line {
+		return len(buf)
+	}
+	if c.offset < len(buf) && buf[c.offset] == '\n' && c.after_cr {
+		// the second half of CRLF
+		c.offset++
+	}
+	for c.col < pos.Character && c.offset < len(buf) {
+		b := c.buf[c.offset]
+		if b == '\n' || b == '\r' {
+			break
+		}
+		c.advance_line(c.offset + 1 + utf8_tail(buf[c.offset:]))
+	}
+	return c.offset
+}
+
+// utf8_tail returns the number of continuation bytes of the sequence at the
+// start of b.
+func utf8_tail(b []byte) int {
+	_, size := utf8.DecodeRune(b)
+	return size - 1
+}
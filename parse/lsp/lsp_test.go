@@ -0,0 +1,248 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"unicode"
+
+	"github.com/adnsv/go-parse/parse"
+)
+
+func bindings() []*parse.Binding[string] {
+	return []*parse.Binding[string]{
+		parse.Bind("ws", "whitespace", parse.Skip(parse.OneOrMore(unicode.IsSpace))),
+		parse.Bind("comment", "comment", parse.Between("/*", "*/")),
+		parse.Bind("keyword", "keyword", parse.AnyOf("func", "var")),
+		parse.Bind("ident", "identifier", parse.OneOrMore(func(c rune) bool { return c > ' ' && c != '/' })),
+	}
+}
+
+func legend() *Legend[string] {
+	return NewLegend(map[string]Style{
+		"comment": {Type: "comment"},
+		"keyword": {Type: "keyword"},
+		"ident":   {Type: "variable", Modifiers: []string{"readonly", "declaration"}},
+	})
+}
+
+func TestEncode(t *testing.T) {
+	l := legend()
+	if got, want := l.Types(), []string{"comment", "keyword", "variable"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Types() = %q, want %q", got, want)
+	}
+	if got, want := l.Modifiers(), []string{"declaration", "readonly"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Modifiers() = %q, want %q", got, want)
+	}
+	tests := []struct {
+		src  string
+		want []uint32
+	}{
+		{"", []uint32{}},
+		{"var x", []uint32{0, 0, 3, 1, 0, 0, 4, 1, 2, 3}},
+		{"ж😀 x\n  y", []uint32{0, 0, 3, 2, 3, 0, 4, 1, 2, 3, 1, 2, 1, 2, 3}},
+		{"a /* b\r\n\r\ncd */ e", []uint32{0, 0, 1, 2, 3, 0, 2, 4, 0, 0, 2, 0, 5, 0, 0, 0, 6, 1, 2, 3}},
+		{"a\r/*\n*/\rb", []uint32{0, 0, 1, 2, 3, 1, 0, 2, 0, 0, 1, 0, 2, 0, 0, 1, 0, 1, 2, 3}},
+	}
+	for _, tt := range tests {
+		tokens, err := parse.TokenizeAll([]byte(tt.src), bindings(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := l.Encode([]byte(tt.src), tokens); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Encode(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestOffset(t *testing.T) {
+	buf := []byte("ab\r\nж😀c\rd\n")
+	tests := []struct {
+		pos  Position
+		want int
+	}{
+		{Position{0, 0}, 0},
+		{Position{0, 2}, 2},
+		{Position{0, 9}, 2},
+		{Position{1, 0}, 4},
+		{Position{1, 1}, 6},
+		{Position{1, 3}, 10},
+		{Position{1, 4}, 11},
+		{Position{2, 0}, 12},
+		{Position{3, 0}, 14},
+		{Position{7, 1}, 14},
+	}
+	for _, tt := range tests {
+		if got := Offset(buf, tt.pos); got != tt.want {
+			t.Errorf("Offset(%+v) = %d, want %d", tt.pos, got, tt.want)
+		}
+	}
+}
+
+// client talks to a server running in the same process.
+type client struct {
+	t   *testing.T
+	w   io.Writer
+	r   *bufio.Reader
+	ids int
+}
+
+// message builds a request or a notification, without params if nil.
+func message(method string, params any) map[string]any {
+	msg := map[string]any{"jsonrpc": "2.0", "method": method}
+	if params != nil {
+		msg["params"] = params
+	}
+	return msg
+}
+
+func (c *client) notify(method string, params any) {
+	c.t.Helper()
+	if err := write_message(c.w, message(method, params)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) call(method string, params any, result any) *ResponseError {
+	c.t.Helper()
+	c.ids++
+	msg := message(method, params)
+	msg["id"] = c.ids
+	if err := write_message(c.w, msg); err != nil {
+		c.t.Fatal(err)
+	}
+	body, err := read_message(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	resp := response{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		c.t.Fatal(err)
+	}
+	if string(resp.ID) != fmt.Sprint(c.ids) {
+		c.t.Fatalf("%s: response id %s, want %d", method, resp.ID, c.ids)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		c.t.Fatal(err)
+	}
+	return nil
+}
+
+func TestServer(t *testing.T) {
+	in_r, in_w := io.Pipe()
+	out_r, out_w := io.Pipe()
+	srv := NewServer(bindings(), legend(), nil)
+	done := make(chan error)
+	go func() {
+		done <- srv.Serve(in_r, out_w)
+	}()
+	c := &client{t: t, w: in_w, r: bufio.NewReader(out_r)}
+
+	var init struct {
+		Capabilities struct {
+			SemanticTokensProvider struct {
+				Legend struct {
+					TokenTypes []string `json:"tokenTypes"`
+				} `json:"legend"`
+			} `json:"semanticTokensProvider"`
+		} `json:"capabilities"`
+	}
+	if err := c.call("initialize", map[string]any{}, &init); err != nil {
+		t.Fatal(err)
+	}
+	if got := init.Capabilities.SemanticTokensProvider.Legend.TokenTypes; !reflect.DeepEqual(got, legend().Types()) {
+		t.Errorf("token types %q", got)
+	}
+	c.notify("initialized", map[string]any{})
+
+	const uri = "file:///test.txt"
+	doc := "func f /* x */\nvar ж = y\n"
+	c.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "test", "version": 1, "text": doc},
+	})
+	var full struct {
+		ResultID string   `json:"resultId"`
+		Data     []uint32 `json:"data"`
+	}
+	id := map[string]any{"uri": uri}
+	if err := c.call("textDocument/semanticTokens/full", map[string]any{"textDocument": id}, &full); err != nil {
+		t.Fatal(err)
+	}
+	data := full.Data
+
+	// edits, as an editor would send them
+	changes := []struct {
+		start, end Position
+		text       string
+	}{
+		{Position{1, 4}, Position{1, 5}, "😀"},
+		{Position{0, 9}, Position{0, 9}, "*/ z /*"},
+		{Position{0, 7}, Position{0, 9}, ""},
+		{Position{2, 0}, Position{2, 0}, "/* open"},
+		{Position{0, 0}, Position{3, 0}, "var\r\nx"},
+	}
+	for i, ch := range changes {
+		start, end := Offset([]byte(doc), ch.start), Offset([]byte(doc), ch.end)
+		doc = doc[:start] + ch.text + doc[end:]
+		c.notify("textDocument/didChange", map[string]any{
+			"textDocument":   map[string]any{"uri": uri, "version": 2 + i},
+			"contentChanges": []any{map[string]any{"range": map[string]any{"start": ch.start, "end": ch.end}, "text": ch.text}},
+		})
+		var delta struct {
+			ResultID string               `json:"resultId"`
+			Edits    []SemanticTokensEdit `json:"edits"`
+		}
+		params := map[string]any{"textDocument": id, "previousResultId": full.ResultID}
+		if err := c.call("textDocument/semanticTokens/full/delta", params, &delta); err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range delta.Edits {
+			data = append(data[:e.Start:e.Start], append(e.Data, data[e.Start+e.DeleteCount:]...)...)
+		}
+		full.ResultID = delta.ResultID
+
+		tokens, _ := parse.TokenizeAll([]byte(doc), bindings(), nil)
+		if want := legend().Encode([]byte(doc), tokens); !reflect.DeepEqual(data, want) {
+			t.Errorf("%q: semantic tokens after delta %v, want %v", doc, data, want)
+		}
+	}
+
+	// a stale result id produces the full tokens
+	var again struct {
+		Data []uint32 `json:"data"`
+	}
+	params := map[string]any{"textDocument": id, "previousResultId": "0"}
+	if err := c.call("textDocument/semanticTokens/full/delta", params, &again); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.Data, data) {
+		t.Errorf("semantic tokens %v, want %v", again.Data, data)
+	}
+
+	if err := c.call("textDocument/hover", map[string]any{"textDocument": id}, nil); err == nil || err.Code != CodeMethodNotFound {
+		t.Errorf("hover error = %v, want code %d", err, CodeMethodNotFound)
+	}
+	if err := c.call("textDocument/hover", []int{1}, nil); err == nil || err.Code != CodeMethodNotFound {
+		t.Errorf("hover with malformed params error = %v, want code %d", err, CodeMethodNotFound)
+	}
+	if err := c.call("textDocument/semanticTokens/full", nil, nil); err == nil || err.Code != CodeInvalidParams || err.Message != `unknown document ""` {
+		t.Errorf("semantic tokens without params error = %v, want unknown document", err)
+	}
+	if err := c.call("textDocument/semanticTokens/full", []int{1}, nil); err == nil || err.Code != CodeInvalidParams {
+		t.Errorf("semantic tokens with malformed params error = %v, want code %d", err, CodeInvalidParams)
+	}
+	var null any
+	if err := c.call("shutdown", nil, &null); err != nil {
+		t.Fatal(err)
+	}
+	c.notify("exit", nil)
+	if err := <-done; err != nil {
+		t.Errorf("Serve() = %v", err)
+	}
+}
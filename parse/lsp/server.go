@@ -0,0 +1,313 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/adnsv/go-parse/parse"
+)
+
+// Server is a language server that provides the semantic tokens of the
+// documents opened by the client. The documents are synchronized
+// incrementally, and re-tokenized with parse.Retokenize on every change.
+// Tokenization errors are not reported; the tokens before an error are
+// highlighted, the rest of the document is not.
+type Server[T comparable] struct {
+	bindings []*parse.Binding[T]
+	legend   *Legend[T]
+	opts     *parse.Options
+	docs     map[string]*document[T]
+	results  int // the last result id
+	shutdown bool
+}
+
+type document[T comparable] struct {
+	buf       []byte
+	tokens    []parse.Token[T]
+	result_id string // of the semantic tokens last sent
+	data      []uint32
+}
+
+// NewServer creates a server that tokenizes the documents with the
+// bindings and opts, and highlights them according to the legend.
+func NewServer[T comparable](bindings []*parse.Binding[T], legend *Legend[T], opts *parse.Options) *Server[T] {
+	return &Server[T]{
+		bindings: bindings,
+		legend:   legend,
+		opts:     opts,
+		docs:     map[string]*document[T]{},
+	}
+}
+
+// Serve reads the JSON-RPC messages from r and writes the responses to w,
+// normally the standard input and output, until the exit notification or
+// the end of r.
+func (s *Server[T]) Serve(r io.Reader, w io.Writer) error {
+	in := bufio.NewReader(r)
+	for {
+		body, err := read_message(in)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		msg := request{}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			err = write_message(w, response{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: &ResponseError{Code: CodeParseError, Message: err.Error()}})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, rerr := s.handle(msg.Method, msg.Params)
+		if msg.ID == nil {
+			// notifications are not answered
+			continue
+		}
+		resp := response{JSONRPC: "2.0", ID: msg.ID}
+		if rerr != nil {
+			resp.Error = rerr
+		} else if resp.Result, err = json.Marshal(result); err != nil {
+			return err
+		}
+		if err := write_message(w, resp); err != nil {
+			return err
+		}
+	}
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *ResponseError  `json:"error,omitempty"`
+}
+
+// ResponseError is a JSON-RPC error.
+type ResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// The JSON-RPC and protocol error codes used by Server.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+)
+
+type text_document struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type content_change struct {
+	Range *struct {
+		Start Position `json:"start"`
+		End   Position `json:"end"`
+	} `json:"range"`
+	Text string `json:"text"`
+}
+
+type semantic_tokens struct {
+	ResultID string   `json:"resultId"`
+	Data     []uint32 `json:"data"`
+}
+
+type semantic_tokens_delta struct {
+	ResultID string               `json:"resultId"`
+	Edits    []SemanticTokensEdit `json:"edits"`
+}
+
+// SemanticTokensEdit replaces DeleteCount integers at Start of the previous
+// semantic tokens with Data.
+type SemanticTokensEdit struct {
+	Start       int      `json:"start"`
+	DeleteCount int      `json:"deleteCount"`
+	Data        []uint32 `json:"data,omitempty"`
+}
+
+// Delta returns the edits that turn the semantic tokens prev into data: at
+// most one edit that replaces everything between the common prefix and
+// suffix.
+func Delta(prev, data []uint32) []SemanticTokensEdit {
+	n := 0
+	for n < len(prev) && n < len(data) && prev[n] == data[n] {
+		n++
+	}
+	m := 0
+	for m < len(prev)-n && m < len(data)-n && prev[len(prev)-1-m] == data[len(data)-1-m] {
+		m++
+	}
+	if n == len(prev) && n == len(data) {
+		return []SemanticTokensEdit{}
+	}
+	return []SemanticTokensEdit{{Start: n, DeleteCount: len(prev) - n - m, Data: data[n : len(data)-m]}}
+}
+
+func (s *Server[T]) handle(method string, params json.RawMessage) (any, *ResponseError) {
+	if s.shutdown {
+		return nil, &ResponseError{Code: CodeInvalidRequest, Message: "server is shut down"}
+	}
+	switch method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"positionEncoding": "utf-16",
+				"textDocumentSync": map[string]any{
+					"openClose": true,
+					"change":    2, // incremental
+				},
+				"semanticTokensProvider": map[string]any{
+					"legend": map[string]any{
+						"tokenTypes":     s.legend.Types(),
+						"tokenModifiers": s.legend.Modifiers(),
+					},
+					"full": map[string]any{"delta": true},
+				},
+			},
+		}, nil
+	case "initialized", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	}
+
+	switch method {
+	case "textDocument/didOpen",
+		"textDocument/didClose",
+		"textDocument/didChange",
+		"textDocument/semanticTokens/full",
+		"textDocument/semanticTokens/full/delta":
+	default:
+		return nil, &ResponseError{Code: CodeMethodNotFound, Message: "unsupported method " + strconv.Quote(method)}
+	}
+
+	var p struct {
+		TextDocument     text_document    `json:"textDocument"`
+		ContentChanges   []content_change `json:"contentChanges"`
+		PreviousResultID string           `json:"previousResultId"`
+	}
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &ResponseError{Code: CodeInvalidParams, Message: err.Error()}
+		}
+	}
+	uri := p.TextDocument.URI
+	doc := s.docs[uri]
+	switch method {
+	case "textDocument/didOpen":
+		doc = &document[T]{buf: []byte(p.TextDocument.Text)}
+		doc.tokens, _ = parse.TokenizeAll(doc.buf, s.bindings, s.opts)
+		s.docs[uri] = doc
+		return nil, nil
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		return nil, nil
+	case "textDocument/didChange",
+		"textDocument/semanticTokens/full",
+		"textDocument/semanticTokens/full/delta":
+		if doc == nil {
+			return nil, &ResponseError{Code: CodeInvalidParams, Message: "unknown document " + strconv.Quote(uri)}
+		}
+	}
+
+	switch method {
+	case "textDocument/didChange":
+		for _, ch := range p.ContentChanges {
+			doc.change(ch, s.bindings, s.opts)
+		}
+		return nil, nil
+	case "textDocument/semanticTokens/full":
+		return semantic_tokens{ResultID: s.result(doc), Data: doc.data}, nil
+	default:
+		prev, prev_id := doc.data, doc.result_id
+		id := s.result(doc)
+		if p.PreviousResultID != prev_id || prev_id == "" {
+			return semantic_tokens{ResultID: id, Data: doc.data}, nil
+		}
+		return semantic_tokens_delta{ResultID: id, Edits: Delta(prev, doc.data)}, nil
+	}
+}
+
+// result encodes the semantic tokens of doc under a new result id.
+func (s *Server[T]) result(doc *document[T]) string {
+	s.results++
+	doc.result_id = strconv.Itoa(s.results)
+	doc.data = s.legend.Encode(doc.buf, doc.tokens)
+	return doc.result_id
+}
+
+func (doc *document[T]) change(ch content_change, bindings []*parse.Binding[T], opts *parse.Options) {
+	if ch.Range == nil {
+		doc.buf = []byte(ch.Text)
+		doc.tokens, _ = parse.TokenizeAll(doc.buf, bindings, opts)
+		return
+	}
+	start := Offset(doc.buf, ch.Range.Start)
+	end := Offset(doc.buf, ch.Range.End)
+	if end < start {
+		end = start
+	}
+	e := parse.Edit{Offset: start, Deleted: end - start, Inserted: ch.Text}
+	doc.buf = e.Apply(doc.buf)
+	doc.tokens, _ = parse.Retokenize(doc.buf, doc.tokens, e, bindings, opts)
+}
+
+// read_message reads the content of a message with the base protocol
+// headers.
+func read_message(r *bufio.Reader) ([]byte, error) {
+	tp := textproto.NewReader(r)
+	h, err := tp.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(h) == 0 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(h.Get("Content-Length")))
+	if err != nil || n < 0 {
+		return nil, errors.New("lsp: invalid Content-Length header")
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// write_message writes v as a message with the base protocol headers.
+func write_message(w io.Writer, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
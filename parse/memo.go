@@ -0,0 +1,214 @@
+package parse
+
+import "sync/atomic"
+
+// Memo is a packrat memoization table for the terms created with Memoize.
+// When a source is configured with Options.Memo, the outcome of a memoized
+// term at a given offset, i.e. its error code, end position, captured text
+// and values, is computed once and then replayed whenever the term is tried
+// again at that offset. This bounds the work done by a grammar to the number
+// of memoized terms times the length of the input.
+//
+// The combinators of this package do not backtrack, so a term is only tried
+// again at the same offset after it did not match, e.g. by the alternatives
+// of FirstOf or by the bindings of Tokenize. Grammars in which alternatives
+// start with the same rules, nested at several levels, are exponential
+// without memoization and linear with it.
+//
+// A Memo is reset by StaticWith and must not be shared by sources in use at
+// the same time.
+type Memo struct {
+	// MaxEntries bounds the number of cached outcomes, defaults to 65536.
+	// When the table is full, the outcomes before the start of the current
+	// token are dropped, or all of them if that does not free at least half
+	// of the table.
+	MaxEntries int
+
+	entries map[memo_key]memo_entry
+	stats   MemoStats
+}
+
+// MemoStats reports the effectiveness of a Memo.
+type MemoStats struct {
+	Hits      int // outcomes replayed from the table
+	Misses    int // outcomes computed by the memoized terms
+	Evictions int // outcomes dropped to stay within MaxEntries
+	Entries   int // outcomes currently in the table
+}
+
+// HitRate returns the share of the memoized term calls that were replayed.
+func (s MemoStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Stats returns the statistics collected since the last reset.
+func (m *Memo) Stats() MemoStats {
+	s := m.stats
+	s.Entries = len(m.entries)
+	return s
+}
+
+type memo_key struct {
+	rule   int64
+	offset int
+}
+
+type memo_entry struct {
+	ec      ErrCode
+	end     static_state
+	level   memo_level
+	text    string
+	values  []any
+	failure failure
+}
+
+// memo_level is what an outcome was computed with; it can be replayed for
+// calls that require the same or a lower level.
+type memo_level int
+
+const (
+	memo_bare      = memo_level(iota) // without a context
+	memo_muted                        // with a context, capturing suspended
+	memo_capturing                    // with a capturing context
+)
+
+func level_of(ctx *Context) memo_level {
+	switch {
+	case ctx == nil:
+		return memo_bare
+	case ctx.muted > 0:
+		return memo_muted
+	default:
+		return memo_capturing
+	}
+}
+
+// memo_rules numbers the memoized terms.
+var memo_rules int64
+
+// Memoize creates a term that matches the same content as the sequence, with
+// its outcomes cached in Options.Memo. Without a Memo, or with sources other
+// than Static, the sequence is matched as is:
+//
+//	operand := Memoize(FirstOf(number, ident, group))
+//	...
+//	err := TokenizeWith(buf, bindings, on_token, &Options{Memo: &Memo{}})
+//
+// Memoization is skipped while Tokenize collects the expectations for an
+// error message, so that the message is the same as without it.
+func Memoize(content ...any) TermFunc {
+	v := Sequence(content...)
+	rule := atomic.AddInt64(&memo_rules, 1)
+	return func(src Source, ctx *Context) ErrCode {
+		if _, ok := src.(*describe); ok {
+			// keep Compile from looking through to v, see node_of
+			return ErrCodeUnmatched
+		}
+		s, _ := src.(*static_impl)
+		if s != nil && s.tick() {
+			return ErrCodeUnmatched
+		}
+		if s == nil || s.memo == nil || ctx != nil && ctx.track != nil {
+			return v(src, ctx)
+		}
+		return s.memo.run(rule, v, s, ctx)
+	}
+}
+
+func (m *Memo) run(rule int64, v TermFunc, s *static_impl, ctx *Context) ErrCode {
+	key := memo_key{rule: rule, offset: s.pos}
+	level := level_of(ctx)
+	if e, ok := m.entries[key]; ok && e.level >= level {
+		m.stats.Hits++
+		m.replay(&e, s, ctx)
+		return e.ec
+	}
+
+	m.stats.Misses++
+	n_text, n_values := 0, 0
+	if ctx != nil {
+		n_text, n_values = ctx.Len(), len(ctx.Values)
+	}
+	ec := v(s, ctx)
+	if s.err != nil {
+		// the source is stopped, there is nothing to replay
+		return ec
+	}
+	e := memo_entry{ec: ec, end: s.state(), level: level}
+	if ctx != nil {
+		if level == memo_capturing {
+			e.text = ctx.text()[n_text:]
+		}
+		if len(ctx.Values) > n_values {
+			e.values = append([]any(nil), ctx.Values[n_values:]...)
+		}
+		e.failure = ctx.failure
+	}
+	m.store(key, e, s.token_start)
+	return ec
+}
+
+// replay reproduces the outcome of e for the source positioned at the offset
+// at which e was computed.
+func (m *Memo) replay(e *memo_entry, s *static_impl, ctx *Context) {
+	if s.lines != nil {
+		// fills the line index
+		s.advance_to(e.end.pos)
+	} else {
+		s.restore(e.end)
+		s.watched()
+	}
+	if ctx == nil {
+		return
+	}
+	if ctx.muted == 0 {
+		ctx.WriteString(e.text)
+	}
+	ctx.Values = append(ctx.Values, e.values...)
+	if e.ec != ErrCodeNone && e.ec != ErrCodeUnmatched && ctx.failure.what == "" {
+		ctx.failure = e.failure
+	}
+}
+
+func (m *Memo) store(key memo_key, e memo_entry, token_start int) {
+	max := m.MaxEntries
+	if max <= 0 {
+		max = 1 << 16
+	}
+	if _, ok := m.entries[key]; !ok && len(m.entries) >= max {
+		m.evict(token_start, max)
+	}
+	m.entries[key] = e
+}
+
+// evict drops the outcomes before offset, or all of them if that leaves
+// more than half of max.
+func (m *Memo) evict(offset, max int) {
+	n := len(m.entries)
+	for k := range m.entries {
+		if k.offset < offset {
+			delete(m.entries, k)
+		}
+	}
+	if len(m.entries) > max/2 {
+		m.entries = make(map[memo_key]memo_entry)
+	}
+	m.stats.Evictions += n - len(m.entries)
+}
+
+func (m *Memo) reset() {
+	if m.entries == nil || len(m.entries) > 0 {
+		m.entries = make(map[memo_key]memo_entry)
+	}
+	m.stats = MemoStats{}
+}
+
+// merge adds the statistics of other, see TokenizeParallel.
+func (m *Memo) merge(other *Memo) {
+	m.stats.Hits += other.stats.Hits
+	m.stats.Misses += other.stats.Misses
+	m.stats.Evictions += other.stats.Evictions
+}
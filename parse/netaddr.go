@@ -0,0 +1,322 @@
+package parse
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+)
+
+var ipv4_octet = Uint[uint8]("", 10, 255)
+
+// IPv4 matches and captures a dotted-quad IPv4 address such as `192.168.0.1`
+// and appends the corresponding netip.Addr value to ctx.Values.
+//
+// Returned values are:
+//
+//   - `ErrCodeUnmatched` if src does not start with a digit
+//   - `ErrCodeIncomplete` if src contains less than 4 octets
+//   - `ErrCodeOverflow` if an octet exceeds 255
+//   - `ErrCodeInvalid` if an octet has leading zeros
+//   - `ErrCodeNone` if src contains a valid address
+func IPv4(src Source, ctx *Context) ErrCode {
+	var a [4]byte
+	for i := range a {
+		if i > 0 {
+			if !src.Hop('.') {
+				return ErrCodeIncomplete
+			}
+			if ctx != nil {
+				ctx.WriteByte('.')
+			}
+		}
+		octet := Context{}
+		ec := ipv4_octet(src, &octet)
+		if ec == ErrCodeUnmatched && i > 0 {
+			return ErrCodeIncomplete
+		} else if ec != ErrCodeNone {
+			return ec
+		}
+		s := octet.String()
+		if ctx != nil {
+			ctx.WriteString(s)
+		}
+		if len(s) > 1 && s[0] == '0' {
+			return ErrCodeInvalid
+		}
+		a[i] = octet.Values[0].(uint8)
+	}
+	if ctx != nil {
+		ctx.Values = append(ctx.Values, netip.AddrFrom4(a))
+	}
+	return ErrCodeNone
+}
+
+// IPv6 matches and captures an IPv6 address in any of the RFC 4291 text
+// forms, including `::` compression, embedded IPv4 (`::ffff:10.0.0.1`) and
+// an optional `%zone` suffix. The corresponding netip.Addr value is appended
+// to ctx.Values.
+//
+// Returned values are:
+//
+//   - `ErrCodeUnmatched` if src does not start with a hex digit or a colon,
+//     or with text that has the shape of an IPv6 address, i.e. contains
+//     `::` or at least two colons
+//   - `ErrCodeInvalid` if the matched text is not a valid IPv6 address
+//   - `ErrCodeNone` if src contains a valid address
+//
+// Words made of hex digits, such as `cafe`, are not consumed, see Rewinder.
+func IPv6(src Source, ctx *Context) ErrCode {
+	s := scan_addr(src, ipv6_shaped)
+	if s == "" {
+		return ErrCodeUnmatched
+	}
+	if ctx != nil {
+		ctx.WriteString(s)
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil || !a.Is6() {
+		return ErrCodeInvalid
+	}
+	if ctx != nil {
+		ctx.Values = append(ctx.Values, a)
+	}
+	return ErrCodeNone
+}
+
+// CIDR matches and captures an IPv4 or IPv6 prefix in the CIDR notation such
+// as `10.0.0.0/8` or `2001:db8::/32` and appends the corresponding
+// netip.Prefix value to ctx.Values.
+//
+// Returned values are:
+//
+//   - `ErrCodeUnmatched` if src does not start with a hex digit or a colon,
+//     or with text that has the shape of an address, i.e. four dot
+//     separated numbers or the shape of an IPv6 address as for IPv6
+//   - `ErrCodeIncomplete` if the prefix length is missing
+//   - `ErrCodeOverflow` if the prefix length exceeds the address size
+//   - `ErrCodeInvalid` if the address part is not valid or the prefix length
+//     has leading zeros
+//   - `ErrCodeNone` if src contains a valid prefix
+//
+// As with IPv6, text without the shape of an address is not consumed.
+func CIDR(src Source, ctx *Context) ErrCode {
+	s := scan_addr(src, func(s string) bool { return ipv4_shaped(s) || ipv6_shaped(s) })
+	if s == "" {
+		return ErrCodeUnmatched
+	}
+	if ctx != nil {
+		ctx.WriteString(s)
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil || a.Zone() != "" {
+		return ErrCodeInvalid
+	}
+	if !src.Hop('/') {
+		return ErrCodeIncomplete
+	}
+	if ctx != nil {
+		ctx.WriteByte('/')
+	}
+	bits := Context{}
+	ec := ipv4_octet(src, &bits)
+	if ec == ErrCodeUnmatched {
+		return ErrCodeIncomplete
+	} else if ec != ErrCodeNone {
+		return ec
+	}
+	text := bits.String()
+	if ctx != nil {
+		ctx.WriteString(text)
+	}
+	if len(text) > 1 && text[0] == '0' {
+		return ErrCodeInvalid
+	}
+	n := int(bits.Values[0].(uint8))
+	if n > a.BitLen() {
+		return ErrCodeOverflow
+	}
+	if ctx != nil {
+		ctx.Values = append(ctx.Values, netip.PrefixFrom(a, n))
+	}
+	return ErrCodeNone
+}
+
+// MAC matches and captures a 48-bit MAC address written as six pairs of hex
+// digits separated by either colons or hyphens (`01:23:45:67:89:ab`,
+// `01-23-45-67-89-AB`) and appends the corresponding net.HardwareAddr value
+// to ctx.Values.
+//
+// Returned values are:
+//
+//   - `ErrCodeUnmatched` if src does not start with a hex digit
+//   - `ErrCodeIncomplete` if src contains less than 6 pairs
+//   - `ErrCodeInvalid` if a pair has a wrong number of digits or the
+//     separators are mixed
+//   - `ErrCodeNone` if src contains a valid address
+func MAC(src Source, ctx *Context) ErrCode {
+	addr := make(net.HardwareAddr, 6)
+	sep := Unmatched
+	for i := range addr {
+		if i == 1 {
+			sep = src.Fetch(func(c rune) bool { return c == ':' || c == '-' })
+			if sep == Unmatched {
+				return ErrCodeIncomplete
+			}
+			if ctx != nil {
+				ctx.WriteRune(sep)
+			}
+		} else if i > 1 {
+			if !src.Hop(sep) {
+				if src.Fetch(func(c rune) bool { return c == ':' || c == '-' }) != Unmatched {
+					return ErrCodeInvalid
+				}
+				return ErrCodeIncomplete
+			}
+			if ctx != nil {
+				ctx.WriteRune(sep)
+			}
+		}
+		n := 0
+		for n < 3 {
+			c := src.Fetch(is_hex)
+			if c == Unmatched {
+				break
+			}
+			if ctx != nil {
+				ctx.WriteRune(c)
+			}
+			addr[i] = addr[i]<<4 | byte(hex(c))
+			n++
+		}
+		switch {
+		case n == 0 && i == 0:
+			return ErrCodeUnmatched
+		case n == 0:
+			return ErrCodeIncomplete
+		case n != 2:
+			return ErrCodeInvalid
+		}
+	}
+	if ctx != nil {
+		ctx.Values = append(ctx.Values, addr)
+	}
+	return ErrCodeNone
+}
+
+// Hostname matches and captures an RFC 1123 host name: one or more labels
+// separated by dots, each label consisting of 1 to 63 letters, digits, and
+// hyphens, neither starting nor ending with a hyphen. The total length may
+// not exceed 253 characters.
+//
+// Returned values are:
+//
+//   - `ErrCodeUnmatched` if src does not start with a letter or a digit
+//   - `ErrCodeInvalid` if a label ends with a hyphen or a length limit is
+//     exceeded
+//   - `ErrCodeNone` if src contains a valid host name
+//
+// A trailing dot is not consumed, so the term can be used in free text.
+func Hostname(src Source, ctx *Context) ErrCode {
+	c := src.Fetch(is_alnum)
+	if c == Unmatched {
+		return ErrCodeUnmatched
+	}
+	total := 0
+	for {
+		if ctx != nil {
+			ctx.WriteRune(c)
+		}
+		label_len := 1
+		last := c
+		for {
+			c = src.Fetch(func(c rune) bool { return c == '-' || is_alnum(c) })
+			if c == Unmatched {
+				break
+			}
+			if ctx != nil {
+				ctx.WriteRune(c)
+			}
+			last = c
+			label_len++
+		}
+		if last == '-' || label_len > 63 {
+			return ErrCodeInvalid
+		}
+		total += label_len
+		c = src.Skip(".", is_alnum)
+		if c == Unmatched {
+			break
+		}
+		if ctx != nil {
+			ctx.WriteByte('.')
+		}
+		total++
+	}
+	if total > 253 {
+		return ErrCodeInvalid
+	}
+	return ErrCodeNone
+}
+
+// scan_addr consumes the text of an address with scan_addr_text if shaped
+// reports that it looks like one. Otherwise, the source is rewound and the
+// result is empty; if it is not a Rewinder, the text is returned anyway.
+func scan_addr(src Source, shaped func(string) bool) string {
+	rw, _ := src.(Rewinder)
+	var mark any
+	if rw != nil {
+		mark = rw.Mark()
+	}
+	text := scan_addr_text(src)
+	if text != "" && rw != nil && !shaped(text) {
+		rw.Rewind(mark)
+		return ""
+	}
+	return text
+}
+
+func ipv6_shaped(s string) bool {
+	return strings.Contains(s, "::") || strings.Count(s, ":") >= 2
+}
+
+func ipv4_shaped(s string) bool {
+	return strings.Count(s, ".") == 3 && strings.Trim(s, "0123456789.") == ""
+}
+
+// scan_addr_text consumes the characters that may constitute an IPv4 or an
+// IPv6 address. Separators are only consumed when followed by something that
+// may continue the address.
+func scan_addr_text(src Source) string {
+	b := strings.Builder{}
+	for {
+		if c := src.Fetch(is_hex); c != Unmatched {
+			b.WriteRune(c)
+		} else if c := src.Skip(":", func(c rune) bool { return c == ':' || is_hex(c) }); c != Unmatched {
+			b.WriteByte(':')
+			b.WriteRune(c)
+		} else if c := src.Skip(".", is_dec); c != Unmatched {
+			b.WriteByte('.')
+			b.WriteRune(c)
+		} else {
+			break
+		}
+	}
+	if b.Len() > 0 {
+		if c := src.Skip("%", is_zone); c != Unmatched {
+			b.WriteByte('%')
+			b.WriteRune(c)
+			for c = src.Fetch(is_zone); c != Unmatched; c = src.Fetch(is_zone) {
+				b.WriteRune(c)
+			}
+		}
+	}
+	return b.String()
+}
+
+func is_alnum(c rune) bool {
+	return c < 0x80 && hex(c) < 36
+}
+
+func is_zone(c rune) bool {
+	return c == '_' || c == '.' || c == '-' || is_alnum(c)
+}
@@ -0,0 +1,72 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNetAddr(t *testing.T) {
+	long_label := strings.Repeat("a", 64)
+	long_name := strings.Repeat("abcdefghi.", 25) + "abcd"
+	tests := []struct {
+		term  TermFunc
+		input string
+		want  string
+	}{
+		{IPv4, "192.168.0.1", `ok "192.168.0.1" 192.168.0.1 @11`},
+		{IPv4, "0.0.0.0:80", `ok "0.0.0.0" 0.0.0.0 @7`},
+		{IPv4, "1.2.3", `incomplete "1.2.3" @5`},
+		{IPv4, "1.2.3.", `incomplete "1.2.3." @6`},
+		{IPv4, "1.2.256.4", `overflow "1.2." @7`},
+		{IPv4, "1.02.3.4", `invalid "1.02" @4`},
+		{IPv4, "x", `unmatched "" @0`},
+
+		{IPv6, "::1", `ok "::1" ::1 @3`},
+		{IPv6, "2001:db8::ff00:42:8329", `ok "2001:db8::ff00:42:8329" 2001:db8::ff00:42:8329 @22`},
+		{IPv6, "2001:0db8:0000:0000:0000:ff00:0042:8329", `ok "2001:0db8:0000:0000:0000:ff00:0042:8329" 2001:db8::ff00:42:8329 @39`},
+		{IPv6, "::ffff:10.0.0.1", `ok "::ffff:10.0.0.1" ::ffff:10.0.0.1 @15`},
+		{IPv6, "fe80::1%eth0 x", `ok "fe80::1%eth0" fe80::1%eth0 @12`},
+		{IPv6, "::", `ok "::" :: @2`},
+		{IPv6, "1:2:3", `invalid "1:2:3" @5`},
+		{IPv6, "1::2::3", `invalid "1::2::3" @7`},
+		{IPv6, "12345::1", `invalid "12345::1" @8`},
+		{IPv6, "cafe", `unmatched "" @0`},
+		{IPv6, "dead:beef", `unmatched "" @0`},
+		{IPv6, "1.2.3.4", `unmatched "" @0`},
+		{IPv6, "x", `unmatched "" @0`},
+
+		{CIDR, "10.0.0.0/8", `ok "10.0.0.0/8" 10.0.0.0/8 @10`},
+		{CIDR, "2001:db8::/32", `ok "2001:db8::/32" 2001:db8::/32 @13`},
+		{CIDR, "10.0.0.0/0", `ok "10.0.0.0/0" 10.0.0.0/0 @10`},
+		{CIDR, "10.0.0.0/33", `overflow "10.0.0.0/33" @11`},
+		{CIDR, "::/129", `overflow "::/129" @6`},
+		{CIDR, "10.0.0.0/256", `overflow "10.0.0.0/" @12`},
+		{CIDR, "10.0.0.0/08", `invalid "10.0.0.0/08" @11`},
+		{CIDR, "10.0.0.0", `incomplete "10.0.0.0" @8`},
+		{CIDR, "10.0.0.0/", `incomplete "10.0.0.0/" @9`},
+		{CIDR, "10.0.0.256/8", `invalid "10.0.0.256" @10`},
+		{CIDR, "fe80::1%eth0/64", `invalid "fe80::1%eth0" @12`},
+		{CIDR, "bad/8", `unmatched "" @0`},
+		{CIDR, "10.0/8", `unmatched "" @0`},
+
+		{MAC, "01:23:45:67:89:ab", `ok "01:23:45:67:89:ab" 01:23:45:67:89:ab @17`},
+		{MAC, "01-23-45-67-89-AB", `ok "01-23-45-67-89-AB" 01:23:45:67:89:ab @17`},
+		{MAC, "01:23:45-67:89:ab", `invalid "01:23:45" @9`},
+		{MAC, "01:23:45:67:89", `incomplete "01:23:45:67:89" @14`},
+		{MAC, "01:234:45:67:89:ab", `invalid "01:234" @6`},
+		{MAC, "x", `unmatched "" @0`},
+
+		{Hostname, "example.com", `ok "example.com" @11`},
+		{Hostname, "example.com.", `ok "example.com" @11`},
+		{Hostname, "a-b.c1", `ok "a-b.c1" @6`},
+		{Hostname, "ab-.c", `invalid "ab-" @3`},
+		{Hostname, long_label, `invalid "` + long_label + `" @64`},
+		{Hostname, long_name, `invalid "` + long_name + `" @254`},
+		{Hostname, "-a", `unmatched "" @0`},
+	}
+	for _, tt := range tests {
+		if got := run_term(tt.term, tt.input); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
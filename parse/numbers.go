@@ -83,31 +83,22 @@ func ExtractOct32n(src Source, max_chars int) (v uint32, overflow bool, n_chars
 
 func HexN[T unsigned](prefix string) TermFunc {
 	n_digits := 2 * int(unsafe.Sizeof(T(0)))
-	return func(src Source, ctx *Context) (ec ErrCode) {
+	return func(src Source, ctx *Context) ErrCode {
+		c := src.Skip(prefix, is_hex)
+		if c == Unmatched {
+			return ErrCodeUnmatched
+		}
 		var v T
-		handle_digit := func(r rune) bool {
-			d := hex(r)
-			if d >= 16 {
-				return false
+		for n := 1; ; n++ {
+			ctx.WriteRune(c)
+			v = v*16 + T(hex(c))
+			if n == n_digits {
+				ctx.Values = append(ctx.Values, v)
+				return ErrCodeNone
 			}
-			ctx.WriteRune(r)
-			if ec == ErrCodeNone {
-				v = v*16 + T(d)
+			if c = src.Fetch(is_hex); c == Unmatched {
+				return ErrCodeIncomplete
 			}
-			return true
-		}
-		if src.Skip(prefix, handle_digit) == Unmatched {
-			return ErrCodeUnmatched
-		}
-		n_digits--
-		for n_digits > 0 && src.Fetch(handle_digit) != Unmatched {
-			n_digits--
-		}
-		if n_digits > 0 {
-			return ErrCodeIncomplete
-		} else {
-			ctx.Values = append(ctx.Values, v)
-			return ErrCodeNone
 		}
 	}
 }
@@ -115,16 +106,18 @@ func HexN[T unsigned](prefix string) TermFunc {
 // Uint captures numeric value v from a sequence of one or more digits.
 func Uint[T unsigned | signed](prefix string, base uint, maxval T) TermFunc {
 	match_digit := digit_matcher(base)
+	is_digit := func(r rune) bool { return match_digit(r) < base }
 	overflow_limit := maxval / T(base)
 
 	return func(src Source, ctx *Context) (ec ErrCode) {
+		c := src.Skip(prefix, is_digit)
+		if c == Unmatched {
+			return ErrCodeUnmatched
+		}
 		var v T
-		handle_digit := func(r rune) bool {
-			d := match_digit(r)
-			if d >= base {
-				return false
-			}
-			ctx.WriteRune(r)
+		for ; c != Unmatched; c = src.Fetch(is_digit) {
+			d := match_digit(c)
+			ctx.WriteRune(c)
 			if ec == ErrCodeNone {
 				overflow := v > overflow_limit
 				v *= T(base)
@@ -134,12 +127,6 @@ func Uint[T unsigned | signed](prefix string, base uint, maxval T) TermFunc {
 					ec = ErrCodeOverflow
 				}
 			}
-			return true
-		}
-		if src.Skip(prefix, handle_digit) == Unmatched {
-			return ErrCodeUnmatched
-		}
-		for src.Fetch(handle_digit) != Unmatched {
 		}
 		ctx.Values = append(ctx.Values, v)
 		return
@@ -165,6 +152,10 @@ func is_hex(c rune) bool {
 	return hex(c) < 16
 }
 
+func is_dec(c rune) bool {
+	return dec(c) < 10
+}
+
 func is_oct(c rune) bool {
 	return dec(c) < 8
 }
@@ -0,0 +1,199 @@
+package parse
+
+// InvalidPolicy determines how sources handle invalid UTF-8 sequences. The
+// same policies apply to invalid UTF-16 and UTF-32 code units, except that
+// InvalidLatin1 falls back to InvalidReplace for those.
+type InvalidPolicy int
+
+const (
+	// InvalidReplace decodes each invalid sequence as a single U+FFFD
+	// replacement character.
+	InvalidReplace = InvalidPolicy(iota)
+
+	// InvalidError stops the source with an `invalid utf-8 sequence` error
+	// located at the offending sequence. This happens on any read that
+	// reaches the sequence, including the content compared by Leap and
+	// Skip.
+	InvalidError
+
+	// InvalidPanic panics with an `invalid utf-8 sequence` *ErrContent on
+	// the same reads as InvalidError.
+	InvalidPanic
+
+	// InvalidLatin1 decodes each byte of an invalid sequence as a Latin-1
+	// (ISO 8859-1) character, which is a common fallback for legacy content.
+	InvalidLatin1
+)
+
+// Options configures sources and tokenization. The zero value provides the
+// default behavior. Options apply to the sources created with StaticWith and
+// by the Tokenize functions, see Source.
+type Options struct {
+	Invalid  InvalidPolicy
+	Encoding Encoding
+	Columns  ColumnMode
+	TabWidth int // tab stop width for ColumnVisual, defaults to 8
+
+	// LineBreaks specifies the codepoints that start a new line for
+	// location tracking, defaults to BreakLF.
+	LineBreaks LineBreaks
+
+	// NormalizeNewlines makes the source present CRLF sequences and lone CRs
+	// as LF codepoints. Offsets still refer to the original content.
+	NormalizeNewlines bool
+
+	// LineIndex, if not nil, is reset and then filled with the starts of the
+	// lines as the source consumes content.
+	LineIndex *LineIndex
+
+	// Memo, if not nil, is reset and then caches the outcomes of the terms
+	// created with Memoize.
+	Memo *Memo
+
+	Limits Limits
+}
+
+// Limits bounds the work spent on an input, as a protection against hostile
+// content such as huge unterminated comments or deeply nested brackets.
+// Zero fields mean no limit. Exceeding a limit stops the source, and
+// tokenization fails with an error that matches ErrTokenLength,
+// ErrTokenCount, or ErrDepth.
+type Limits struct {
+	// MaxTokenLen limits the number of bytes consumed by a single token.
+	// Outside of Tokenize, it applies to all the consumed content.
+	MaxTokenLen int
+
+	// MaxTokens limits the number of tokens reported by Tokenize.
+	MaxTokens int
+
+	// MaxDepth limits the nesting of Recursive terms. Each active
+	// invocation counts, including the innermost one that may not match.
+	MaxDepth int
+}
+
+// Encoding specifies how sources decode the input bytes into codepoints.
+// Regardless of the encoding, source offsets refer to the original bytes and
+// the captured content is UTF-8.
+type Encoding int
+
+const (
+	EncodingUTF8 = Encoding(iota)
+	EncodingUTF16LE
+	EncodingUTF16BE
+	EncodingUTF32LE
+	EncodingUTF32BE
+	EncodingLatin1 // ISO 8859-1
+
+	// EncodingAuto detects the encoding from the byte order mark, falling
+	// back to UTF-8 if there is none. The BOM itself is skipped.
+	EncodingAuto
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case EncodingUTF8:
+		return "utf-8"
+	case EncodingUTF16LE:
+		return "utf-16le"
+	case EncodingUTF16BE:
+		return "utf-16be"
+	case EncodingUTF32LE:
+		return "utf-32le"
+	case EncodingUTF32BE:
+		return "utf-32be"
+	case EncodingLatin1:
+		return "latin-1"
+	case EncodingAuto:
+		return "auto"
+	default:
+		return "<unknown>"
+	}
+}
+
+// DetectEncoding examines the byte order mark at the start of buf and returns
+// the corresponding encoding along with the size of the BOM in bytes. If buf
+// does not start with a BOM, it returns EncodingUTF8 and 0.
+func DetectEncoding(buf []byte) (Encoding, int) {
+	has := func(bom ...byte) bool {
+		if len(buf) < len(bom) {
+			return false
+		}
+		for i, b := range bom {
+			if buf[i] != b {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case has(0xEF, 0xBB, 0xBF):
+		return EncodingUTF8, 3
+	case has(0xFF, 0xFE, 0x00, 0x00):
+		return EncodingUTF32LE, 4
+	case has(0x00, 0x00, 0xFE, 0xFF):
+		return EncodingUTF32BE, 4
+	case has(0xFF, 0xFE):
+		return EncodingUTF16LE, 2
+	case has(0xFE, 0xFF):
+		return EncodingUTF16BE, 2
+	default:
+		return EncodingUTF8, 0
+	}
+}
+
+// ColumnMode determines the units in which LineCol.ColumnIndex is counted.
+type ColumnMode int
+
+const (
+	// ColumnCodepoints counts Unicode codepoints.
+	ColumnCodepoints = ColumnMode(iota)
+
+	// ColumnBytes counts bytes of the input in its original encoding.
+	ColumnBytes
+
+	// ColumnUTF16 counts UTF-16 code units, as expected by the Language
+	// Server Protocol and many editors.
+	ColumnUTF16
+
+	// ColumnVisual counts terminal cells: tabs advance to the next tab stop,
+	// East Asian wide and fullwidth characters occupy two cells, combining
+	// marks and control characters occupy none. Emoji sequences joined with
+	// U+200D, emoji with skin tone modifiers and pairs of regional
+	// indicators (flags) occupy the cells of a single emoji. Other grapheme
+	// clusters are not segmented: each of their codepoints is counted on its
+	// own, and a variation selector does not change the width of the
+	// preceding character.
+	ColumnVisual
+)
+
+// LineBreaks is a set of line terminators.
+type LineBreaks int
+
+const (
+	BreakLF  = LineBreaks(1 << iota) // U+000A line feed
+	BreakCR                          // U+000D carriage return, CRLF counts as a single break
+	BreakNEL                         // U+0085 next line
+	BreakLS                          // U+2028 line separator
+	BreakPS                          // U+2029 paragraph separator
+
+	// BreaksUnicode is the set of line terminators recommended by Unicode,
+	// excluding the vertical tab and the form feed.
+	BreaksUnicode = BreakLF | BreakCR | BreakNEL | BreakLS | BreakPS
+)
+
+func (b LineBreaks) has(c rune) bool {
+	switch c {
+	case '\n':
+		return b&BreakLF != 0
+	case '\r':
+		return b&BreakCR != 0
+	case 0x85:
+		return b&BreakNEL != 0
+	case 0x2028:
+		return b&BreakLS != 0
+	case 0x2029:
+		return b&BreakPS != 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,266 @@
+package parse
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"sync"
+)
+
+// ParallelOptions configures TokenizeParallel. The zero value provides the
+// default behavior.
+type ParallelOptions struct {
+	Options
+
+	// Workers is the number of chunks tokenized concurrently, defaults to
+	// runtime.GOMAXPROCS(0).
+	Workers int
+
+	// ChunkSize is the approximate size of the chunks in bytes, defaults to
+	// 1 MiB.
+	ChunkSize int
+
+	// Resync, if not nil, is tried at the start of a line without a
+	// context, and the input is only split before the lines at which it
+	// does not report ErrCodeUnmatched. Without Resync, the input can be
+	// split before any line.
+	Resync TermFunc
+}
+
+// TokenizeParallel is the same as TokenizeWith, but splits buf into chunks
+// that are tokenized concurrently. The chunks start at lines selected by
+// opts.Resync, and it is up to the caller to ensure that no token spans
+// such a line start: the terms see the end of a chunk as the end of input.
+// This fits line-oriented content such as logs or CSV without multi-line
+// fields.
+//
+// The tokens are delivered to on_token in their original order from the
+// calling goroutine, with the locations relative to the whole input, and
+// tokenization stops at the first error, as with TokenizeWith. The bindings
+// are shared by the workers and must be safe for concurrent use, which is
+// the case for all the terms of this package.
+//
+// Input that cannot be split at LF line feeds, i.e. with encodings other
+// than UTF-8 or Latin-1, line breaks without BreakLF, or a LineIndex, is
+// tokenized as a single chunk, the same as with a single worker. If ctx is
+// canceled, TokenizeParallel stops and returns ctx.Err().
+//
+// With opts.Memo, each chunk is memoized in a table of its own, and their
+// statistics are added up in opts.Memo.
+func TokenizeParallel[T Key](ctx context.Context, buf []byte, bindings []*Binding[T], on_token func(k T, c *Context, lc LineCol), opts *ParallelOptions) error {
+	if opts == nil {
+		opts = &ParallelOptions{}
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	bounds := split_points(buf, opts)
+	if workers == 1 || len(bounds) == 2 {
+		return tokenize_context(ctx, StaticWith(buf, nil, &opts.Options), bindings, on_token)
+	}
+	chunks := make([]parallel_chunk[T], len(bounds)-1)
+	for i := range chunks {
+		chunks[i].done = make(chan struct{})
+	}
+
+	if opts.Memo != nil {
+		// the chunks use their own tables, the statistics are merged
+		opts.Memo.reset()
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	wg := sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// at most two chunks per worker are kept in memory ahead of delivery,
+	// their buffers are recycled once delivered
+	jobs := make(chan int)
+	window := make(chan struct{}, 2*workers)
+	free := make(chan parallel_chunk[T], 2*workers)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		for i := range chunks {
+			select {
+			case window <- struct{}{}:
+			case <-cctx.Done():
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-cctx.Done():
+				return
+			}
+		}
+	}()
+	for w := 0; w < workers && w < len(chunks); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				ch := &chunks[i]
+				select {
+				case prev := <-free:
+					ch.tokens, ch.values = prev.tokens[:0], prev.values[:0]
+				default:
+				}
+				ch.tokenize(cctx, buf, bounds[i], bounds[i+1], bindings, &opts.Options)
+				close(ch.done)
+			}
+		}()
+	}
+
+	out := Context{}
+	out.Attach(Static(buf, nil))
+	lines := 0 // line breaks before the current chunk
+	n := 0     // tokens delivered
+	for i := range chunks {
+		ch := &chunks[i]
+		select {
+		case <-ch.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for j := range ch.tokens {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			t := &ch.tokens[j]
+			out.Reset()
+			if t.view_end > t.view_start {
+				out.view_start, out.view_end = t.view_start, t.view_end
+			} else {
+				out.Builder.WriteString(t.text)
+			}
+			out.Values = ch.values[t.values_start:t.values_end:t.values_end]
+			out.Span = t.span
+			out.Span.Start.LineNumber += lines
+			out.Span.End.LineNumber += lines
+			lc := t.lc
+			lc.LineIndex += lines
+			if n++; opts.Limits.MaxTokens > 0 && n > opts.Limits.MaxTokens {
+				return &ErrAtLineCol{Err: ErrTokenCount, Loc: lc, Span: out.Span}
+			}
+			on_token(t.k, &out, lc)
+		}
+		if ch.memo != nil {
+			opts.Memo.merge(ch.memo)
+		}
+		if ch.err != nil {
+			if e, ok := ch.err.(*ErrAtLineCol); ok {
+				e.Loc.LineIndex += lines
+				e.Span.Start.LineNumber += lines
+				e.Span.End.LineNumber += lines
+			}
+			return ch.err
+		}
+		lines += ch.lines
+		select {
+		case free <- *ch:
+		default:
+		}
+		ch.tokens, ch.values = nil, nil
+		<-window
+	}
+	return nil
+}
+
+// split_points returns the offsets of the chunks that buf is split into,
+// starting with 0 and ending with len(buf).
+func split_points(buf []byte, opts *ParallelOptions) []int {
+	bounds := []int{0}
+	enc := opts.Encoding
+	if enc == EncodingAuto {
+		enc, _ = DetectEncoding(buf)
+	}
+	if enc != EncodingUTF8 && enc != EncodingLatin1 ||
+		opts.LineBreaks != 0 && opts.LineBreaks&BreakLF == 0 || opts.LineIndex != nil {
+		return append(bounds, len(buf))
+	}
+	size := opts.ChunkSize
+	if size <= 0 {
+		size = 1 << 20
+	}
+	o := opts.Options
+	o.Encoding = enc
+	for at := size; at < len(buf); {
+		i := bytes.IndexByte(buf[at:], '\n')
+		if i < 0 {
+			break
+		}
+		at += i + 1
+		if at >= len(buf) {
+			break
+		}
+		if opts.Resync != nil {
+			src := StaticWith(buf, nil, &o)
+			src.pos, src.line_pos = at, at
+			if opts.Resync(src, nil) == ErrCodeUnmatched {
+				continue
+			}
+		}
+		bounds = append(bounds, at)
+		at += size
+	}
+	return append(bounds, len(buf))
+}
+
+// parallel_chunk holds the tokens of a chunk until they are delivered.
+type parallel_chunk[T Key] struct {
+	tokens []parallel_token[T]
+	values []any // the values of all the tokens
+	lines  int   // line breaks within the chunk
+	memo   *Memo
+	err    error
+	done   chan struct{}
+}
+
+type parallel_token[T Key] struct {
+	k            T
+	text         string
+	view_start   int // the text is buf[view_start:view_end] if not empty
+	view_end     int
+	values_start int // the values are values[values_start:values_end]
+	values_end   int
+	span         Span
+	lc           LineCol
+}
+
+func (ch *parallel_chunk[T]) tokenize(ctx context.Context, buf []byte, start, end int, bindings []*Binding[T], opts *Options) {
+	o := *opts
+	o.Limits.MaxTokens = 0 // counted on delivery
+	if o.Memo != nil {
+		o.Memo = &Memo{MaxEntries: o.Memo.MaxEntries}
+	}
+	ch.memo = o.Memo
+	if start > 0 && o.Encoding == EncodingAuto {
+		o.Encoding, _ = DetectEncoding(buf)
+	}
+	src := StaticWith(buf[:end], nil, &o)
+	if start > 0 {
+		src.pos, src.line_pos = start, start
+	}
+	ch.err = tokenize_context(ctx, src, bindings, func(k T, c *Context, lc LineCol) {
+		t := parallel_token[T]{k: k, span: c.Span, lc: lc}
+		if c.view_end > c.view_start {
+			t.view_start, t.view_end = c.view_start, c.view_end
+		} else {
+			t.text = c.String()
+		}
+		t.values_start = len(ch.values)
+		ch.values = append(ch.values, c.Values...)
+		t.values_end = len(ch.values)
+		ch.tokens = append(ch.tokens, t)
+	})
+	ch.lines = src.loc.LineIndex
+}
@@ -0,0 +1,118 @@
+package parse
+
+import (
+	"io"
+	"regexp"
+	"unicode/utf8"
+)
+
+// Regexp creates a matcher for a regular expression in the Go regexp
+// syntax. The expression is anchored at the current position and the
+// leftmost-longest match is consumed and captured. The text of each
+// parenthesized subexpression is appended to ctx.Values as a string, with
+// non-participating groups appended as empty strings:
+//
+//	Bind("assign", "assignment", Regexp(`([a-z]+)\s*=\s*([0-9]+)`))
+//
+// An expression that matches empty content succeeds without consuming
+// anything, similar to ZeroOrMore. The regexp package reads ahead of the
+// match, so Regexp requires a source created with Static or StaticWith; it
+// does not match anything with other sources.
+//
+// Returned values are:
+//
+//   - `ErrCodeUnmatched` if src does not start with a match
+//   - `ErrCodeNone` if src starts with a match
+func Regexp(pattern string) TermFunc {
+	re := regexp.MustCompile(`\A(?:` + pattern + `)`)
+	re.Longest()
+	what := "/" + pattern + "/"
+
+	return func(src Source, ctx *Context) ErrCode {
+		s, ok := src.(*static_impl)
+		if !ok {
+			ctx.Expect(src, what)
+			return ErrCodeUnmatched
+		}
+		var loc []int
+		var text []byte
+		// the matchers of the regexp package that take a byte slice prepare
+		// for all of it, the others only read as far as the match goes
+		direct := s.plain && s.end-s.pos <= regexp_window
+		if direct {
+			text = s.buf[s.pos:s.end]
+			loc = re.FindSubmatchIndex(text)
+			if loc != nil && !utf8.Valid(text[:loc[1]]) {
+				// let the decoder apply the invalid policy
+				direct = false
+			}
+		}
+		if !direct {
+			rr := rune_reader{s: s, at: s.pos}
+			loc = re.FindReaderSubmatchIndex(&rr)
+			text = rr.text
+		}
+		if loc == nil {
+			ctx.Expect(src, what)
+			return ErrCodeUnmatched
+		}
+
+		if direct {
+			start := s.pos
+			s.advance_to(start + loc[1])
+			if ctx != nil {
+				ctx.Write(s.buf[start:s.pos])
+			}
+		} else {
+			for n := 0; n < loc[1]; {
+				c := s.Fetch(nil)
+				if c == Unmatched {
+					return ErrCodeNone
+				}
+				n += utf8.RuneLen(c)
+				if ctx != nil {
+					ctx.WriteRune(c)
+				}
+			}
+		}
+		if ctx != nil {
+			for i := 2; i < len(loc); i += 2 {
+				if loc[i] < 0 {
+					ctx.Values = append(ctx.Values, "")
+				} else {
+					ctx.Values = append(ctx.Values, string(text[loc[i]:loc[i+1]]))
+				}
+			}
+		}
+		return ErrCodeNone
+	}
+}
+
+// regexp_window is the length of the content up to which Regexp matches the
+// buffer directly.
+const regexp_window = 4 << 10
+
+// rune_reader decodes the content of a static source ahead of its position
+// without consuming it. The decoded content is collected in text, so that
+// the offsets reported by the regexp package can be resolved.
+type rune_reader struct {
+	s    *static_impl
+	at   int
+	text []byte
+}
+
+func (r *rune_reader) ReadRune() (rune, int, error) {
+	if r.at >= r.s.end {
+		return 0, 0, io.EOF
+	}
+	c, size, ok := r.s.decode(r.at)
+	if !ok {
+		// invalid content ends the match, the source reports it once
+		// consumed
+		return 0, 0, io.EOF
+	}
+	r.at += size
+	n := len(r.text)
+	r.text = utf8.AppendRune(r.text, c)
+	return c, len(r.text) - n, nil
+}
@@ -0,0 +1,538 @@
+package parse
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version as defined by SemVer 2.0.0.
+type Version struct {
+	Major, Minor, Patch uint64
+	Pre                 []string // pre-release identifiers
+	Build               []string // build metadata identifiers
+}
+
+func (v Version) String() string {
+	s := strconv.FormatUint(v.Major, 10) + "." + strconv.FormatUint(v.Minor, 10) + "." + strconv.FormatUint(v.Patch, 10)
+	if len(v.Pre) > 0 {
+		s += "-" + strings.Join(v.Pre, ".")
+	}
+	if len(v.Build) > 0 {
+		s += "+" + strings.Join(v.Build, ".")
+	}
+	return s
+}
+
+// Compare returns -1, 0, or +1 depending on the precedence of v relative to
+// w. Build metadata does not participate in the comparison.
+func (v Version) Compare(w Version) int {
+	if c := cmp_uint(v.Major, w.Major); c != 0 {
+		return c
+	}
+	if c := cmp_uint(v.Minor, w.Minor); c != 0 {
+		return c
+	}
+	if c := cmp_uint(v.Patch, w.Patch); c != 0 {
+		return c
+	}
+	switch {
+	case len(v.Pre) == 0 && len(w.Pre) == 0:
+		return 0
+	case len(v.Pre) == 0:
+		return +1
+	case len(w.Pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Pre) && i < len(w.Pre); i++ {
+		a, a_err := strconv.ParseUint(v.Pre[i], 10, 64)
+		b, b_err := strconv.ParseUint(w.Pre[i], 10, 64)
+		var c int
+		switch {
+		case a_err == nil && b_err == nil:
+			c = cmp_uint(a, b)
+		case a_err == nil:
+			c = -1 // numeric identifiers have lower precedence
+		case b_err == nil:
+			c = +1
+		default:
+			c = strings.Compare(v.Pre[i], w.Pre[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp_uint(uint64(len(v.Pre)), uint64(len(w.Pre)))
+}
+
+func cmp_uint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return +1
+	default:
+		return 0
+	}
+}
+
+// Comparator is a primitive version constraint such as `>=1.2.0`. Op is one
+// of `=`, `!=`, `<`, `<=`, `>`, `>=`.
+type Comparator struct {
+	Op      string
+	Version Version
+}
+
+func (c Comparator) String() string {
+	return c.Op + c.Version.String()
+}
+
+// Check reports whether v satisfies the comparator.
+func (c Comparator) Check(v Version) bool {
+	r := v.Compare(c.Version)
+	switch c.Op {
+	case "=":
+		return r == 0
+	case "!=":
+		return r != 0
+	case "<":
+		return r < 0
+	case "<=":
+		return r <= 0
+	case ">":
+		return r > 0
+	case ">=":
+		return r >= 0
+	default:
+		return false
+	}
+}
+
+// Constraint is a disjunction of comparator sets, where each set is a
+// conjunction of comparators. An empty set matches any version.
+type Constraint struct {
+	Sets [][]Comparator
+}
+
+func (c Constraint) String() string {
+	ss := make([]string, 0, len(c.Sets))
+	for _, set := range c.Sets {
+		cc := make([]string, 0, len(set))
+		for _, cmp := range set {
+			cc = append(cc, cmp.String())
+		}
+		if len(cc) == 0 {
+			cc = append(cc, "*")
+		}
+		ss = append(ss, strings.Join(cc, " "))
+	}
+	return strings.Join(ss, " || ")
+}
+
+// Check reports whether v satisfies the constraint.
+func (c Constraint) Check(v Version) bool {
+outer:
+	for _, set := range c.Sets {
+		for _, cmp := range set {
+			if !cmp.Check(v) {
+				continue outer
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// SemVer matches and captures a SemVer 2.0.0 version such as
+// `1.2.3-rc.1+build.5` and appends the corresponding Version value to
+// ctx.Values.
+//
+// Returned values are:
+//
+//   - `ErrCodeUnmatched` if src does not start with a digit
+//   - `ErrCodeIncomplete` if a version component or an identifier is missing
+//   - `ErrCodeInvalid` if a numeric component or a numeric pre-release
+//     identifier has leading zeros, or if an identifier is empty
+//   - `ErrCodeOverflow` if a numeric component does not fit into uint64
+//   - `ErrCodeNone` if src contains a valid version
+func SemVer(src Source, ctx *Context) ErrCode {
+	p, ec := scan_partial_version(src, ctx, false)
+	if ec != ErrCodeNone {
+		return ec
+	}
+	if p.n < 3 {
+		return ErrCodeIncomplete
+	}
+	if ctx != nil {
+		ctx.Values = append(ctx.Values, p.v)
+	}
+	return ErrCodeNone
+}
+
+// VersionConstraint matches and captures a version constraint expression and
+// appends the corresponding Constraint value to ctx.Values. The supported
+// syntax follows the conventions used by npm and Cargo:
+//
+//	1.2.3           exact version, partial versions match a range
+//	=1.2 !=1.2.3    equality, inequality
+//	>1 >=1.2 <2 <=2.0.1
+//	^1.2.3          compatible with 1.2.3: >=1.2.3 <2.0.0
+//	~1.4            approximately 1.4: >=1.4.0 <1.5.0
+//	1.x 1.2.* *     wildcards
+//	>=1.0 <2.0      conjunction (separated by spaces or commas)
+//	^1 || ^2        disjunction
+//
+// The `x` and `X` wildcards are only accepted after a number, `*` also
+// stands alone.
+//
+// Returned values are:
+//
+//   - `ErrCodeUnmatched` if src does not start with a constraint
+//   - `ErrCodeIncomplete` if an operator is not followed by a version or
+//     `||` is not followed by a comparator set
+//   - `ErrCodeInvalid` if a version is malformed
+//   - `ErrCodeOverflow` if a numeric component does not fit into uint64
+//   - `ErrCodeNone` if src contains a valid constraint
+//
+// The whitespace and the comma that follow the last comparator are not
+// consumed, see Rewinder.
+func VersionConstraint(src Source, ctx *Context) ErrCode {
+	var c Constraint
+	var set []Comparator
+	for {
+		cmps, ec := scan_comparator(src, ctx)
+		if ec == ErrCodeUnmatched && len(c.Sets) > 0 {
+			return ErrCodeIncomplete
+		} else if ec != ErrCodeNone {
+			return ec
+		}
+		set = append(set, cmps...)
+		sep := scan_version_sep(src, ctx)
+		if sep == version_end {
+			break
+		}
+		if sep == version_or {
+			c.Sets = append(c.Sets, set)
+			set = nil
+			skip_version_ws(src, ctx)
+		}
+	}
+	c.Sets = append(c.Sets, set)
+	if ctx != nil {
+		ctx.Values = append(ctx.Values, c)
+	}
+	return ErrCodeNone
+}
+
+type version_sep int
+
+const (
+	version_end = version_sep(iota)
+	version_and // another comparator of the set follows
+	version_or  // another set follows
+)
+
+// scan_version_sep consumes the whitespace, comma or `||` that separates a
+// comparator from the next one. If no comparator follows, the source is
+// rewound to the end of the previous comparator.
+func scan_version_sep(src Source, ctx *Context) version_sep {
+	rw, _ := src.(Rewinder)
+	var mark any
+	if rw != nil {
+		mark = rw.Mark()
+	}
+	sep := strings.Builder{}
+	for c := src.Fetch(is_version_ws); c != Unmatched; c = src.Fetch(is_version_ws) {
+		sep.WriteRune(c)
+	}
+	comma := src.Hop(',')
+	if comma {
+		sep.WriteByte(',')
+		for c := src.Fetch(is_version_ws); c != Unmatched; c = src.Fetch(is_version_ws) {
+			sep.WriteRune(c)
+		}
+	}
+	r := version_end
+	if c := src.Peek(); is_dec(c) || c == '*' || strings.ContainsRune("<>=!^~", c) {
+		r = version_and
+	} else if !comma && src.Leap("||") {
+		sep.WriteString("||")
+		r = version_or
+	}
+	if r == version_end {
+		if rw != nil {
+			rw.Rewind(mark)
+		}
+		return r
+	}
+	if ctx != nil {
+		ctx.WriteString(sep.String())
+	}
+	return r
+}
+
+func skip_version_ws(src Source, ctx *Context) {
+	for c := src.Fetch(is_version_ws); c != Unmatched; c = src.Fetch(is_version_ws) {
+		if ctx != nil {
+			ctx.WriteRune(c)
+		}
+	}
+}
+
+func is_version_ws(c rune) bool {
+	return c == ' ' || c == '\t'
+}
+
+// partial_version is a version with n leading numeric components specified;
+// the rest are wildcards.
+type partial_version struct {
+	v Version
+	n int
+}
+
+func (p partial_version) bump() Version {
+	// the smallest version above the range described by p
+	switch p.n {
+	case 1:
+		return Version{Major: p.v.Major + 1}
+	case 2:
+		return Version{Major: p.v.Major, Minor: p.v.Minor + 1}
+	default:
+		return Version{Major: p.v.Major, Minor: p.v.Minor, Patch: p.v.Patch + 1}
+	}
+}
+
+var version_ops = []string{">=", "<=", "!=", ">", "<", "=", "^", "~"}
+
+func scan_comparator(src Source, ctx *Context) ([]Comparator, ErrCode) {
+	op := ""
+	for _, o := range version_ops {
+		if src.Leap(o) {
+			op = o
+			break
+		}
+	}
+	if op != "" {
+		if ctx != nil {
+			ctx.WriteString(op)
+		}
+		skip_version_ws(src, ctx)
+	}
+	p, ec := scan_partial_version(src, ctx, true)
+	if ec == ErrCodeUnmatched && op != "" {
+		return nil, ErrCodeIncomplete
+	} else if ec != ErrCodeNone {
+		return nil, ec
+	}
+
+	if p.n == 0 {
+		// a bare wildcard matches anything
+		switch op {
+		case "", "=", ">=", "<=", "^", "~":
+			return nil, ErrCodeNone
+		default:
+			return []Comparator{{"<", Version{}}}, ErrCodeNone
+		}
+	}
+
+	lo := p.v
+	switch op {
+	case "", "=":
+		if p.n == 3 {
+			return []Comparator{{"=", lo}}, ErrCodeNone
+		}
+		return []Comparator{{">=", lo}, {"<", p.bump()}}, ErrCodeNone
+	case "!=":
+		if p.n != 3 {
+			return nil, ErrCodeInvalid
+		}
+		return []Comparator{{"!=", lo}}, ErrCodeNone
+	case ">":
+		if p.n == 3 {
+			return []Comparator{{">", lo}}, ErrCodeNone
+		}
+		return []Comparator{{">=", p.bump()}}, ErrCodeNone
+	case ">=":
+		return []Comparator{{">=", lo}}, ErrCodeNone
+	case "<":
+		return []Comparator{{"<", lo}}, ErrCodeNone
+	case "<=":
+		if p.n == 3 {
+			return []Comparator{{"<=", lo}}, ErrCodeNone
+		}
+		return []Comparator{{"<", p.bump()}}, ErrCodeNone
+	case "~":
+		hi := partial_version{v: lo, n: p.n}
+		if hi.n > 2 {
+			hi.n = 2
+		}
+		return []Comparator{{">=", lo}, {"<", hi.bump()}}, ErrCodeNone
+	default: // "^"
+		hi := partial_version{v: lo}
+		switch {
+		case lo.Major > 0 || p.n == 1:
+			hi.n = 1
+		case lo.Minor > 0 || p.n == 2:
+			hi.n = 2
+		default:
+			hi.n = 3
+		}
+		return []Comparator{{">=", lo}, {"<", hi.bump()}}, ErrCodeNone
+	}
+}
+
+var semver_number = Uint[uint64]("", 10, ^uint64(0))
+
+func scan_partial_version(src Source, ctx *Context, allow_partial bool) (p partial_version, ec ErrCode) {
+	is_wildcard := func(c rune) bool { return c == 'x' || c == 'X' || c == '*' }
+	wildcard := false
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			if wildcard && allow_partial {
+				// components after a wildcard must also be wildcards
+				c := src.Skip(".", is_wildcard)
+				if c == Unmatched {
+					break
+				}
+				if ctx != nil {
+					ctx.WriteByte('.')
+					ctx.WriteRune(c)
+				}
+				continue
+			}
+			if allow_partial {
+				c := src.Skip(".", func(c rune) bool { return is_dec(c) || is_wildcard(c) })
+				if c == Unmatched {
+					break
+				}
+				if ctx != nil {
+					ctx.WriteByte('.')
+				}
+				if is_wildcard(c) {
+					if ctx != nil {
+						ctx.WriteRune(c)
+					}
+					wildcard = true
+					continue
+				}
+				if ec = scan_version_component(src, ctx, c, &p, i); ec != ErrCodeNone {
+					return
+				}
+				continue
+			}
+			if !src.Hop('.') {
+				return p, ErrCodeIncomplete
+			}
+			if ctx != nil {
+				ctx.WriteByte('.')
+			}
+		} else if allow_partial {
+			// x and X are taken for words unless they follow a number
+			if c := src.Fetch(func(c rune) bool { return c == '*' }); c != Unmatched {
+				if ctx != nil {
+					ctx.WriteRune(c)
+				}
+				wildcard = true
+				continue
+			}
+		}
+		if ec = scan_version_component(src, ctx, Unmatched, &p, i); ec == ErrCodeUnmatched && i > 0 {
+			return p, ErrCodeIncomplete
+		} else if ec != ErrCodeNone {
+			return
+		}
+	}
+
+	if p.n < 3 {
+		return p, ErrCodeNone
+	}
+	if src.Hop('-') {
+		if ctx != nil {
+			ctx.WriteByte('-')
+		}
+		if p.v.Pre, ec = scan_version_identifiers(src, ctx, true); ec != ErrCodeNone {
+			return
+		}
+	}
+	if src.Hop('+') {
+		if ctx != nil {
+			ctx.WriteByte('+')
+		}
+		if p.v.Build, ec = scan_version_identifiers(src, ctx, false); ec != ErrCodeNone {
+			return
+		}
+	}
+	return p, ErrCodeNone
+}
+
+// scan_version_component reads the i-th numeric component into p; lead is
+// the first digit if it is already consumed.
+func scan_version_component(src Source, ctx *Context, lead rune, p *partial_version, i int) ErrCode {
+	num := Context{}
+	var ec ErrCode
+	if lead != Unmatched {
+		num.WriteRune(lead)
+		rest := Context{}
+		ec = semver_number(src, &rest)
+		if ec == ErrCodeUnmatched {
+			ec = ErrCodeNone
+		}
+		num.WriteString(rest.String())
+	} else {
+		ec = semver_number(src, &num)
+	}
+	if ctx != nil {
+		ctx.WriteString(num.String())
+	}
+	if ec != ErrCodeNone {
+		return ec
+	}
+	s := num.String()
+	if len(s) > 1 && s[0] == '0' {
+		return ErrCodeInvalid
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return ErrCodeOverflow
+	}
+	switch i {
+	case 0:
+		p.v.Major = v
+	case 1:
+		p.v.Minor = v
+	default:
+		p.v.Patch = v
+	}
+	p.n = i + 1
+	return ErrCodeNone
+}
+
+func scan_version_identifiers(src Source, ctx *Context, pre bool) ([]string, ErrCode) {
+	is_ident := func(c rune) bool { return c == '-' || is_alnum(c) }
+	var ids []string
+	for {
+		b := strings.Builder{}
+		for c := src.Fetch(is_ident); c != Unmatched; c = src.Fetch(is_ident) {
+			b.WriteRune(c)
+		}
+		id := b.String()
+		if ctx != nil {
+			ctx.WriteString(id)
+		}
+		if id == "" {
+			if len(ids) == 0 {
+				return nil, ErrCodeIncomplete
+			}
+			return nil, ErrCodeInvalid
+		}
+		if pre && len(id) > 1 && id[0] == '0' && strings.Trim(id, "0123456789") == "" {
+			return nil, ErrCodeInvalid
+		}
+		ids = append(ids, id)
+		if !src.Hop('.') {
+			return ids, ErrCodeNone
+		}
+		if ctx != nil {
+			ctx.WriteByte('.')
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package parse
+
+import "testing"
+
+func TestSemVer(t *testing.T) {
+	tests := []struct {
+		term  TermFunc
+		input string
+		want  string
+	}{
+		{SemVer, "1.2.3", `ok "1.2.3" 1.2.3 @5`},
+		{SemVer, "1.2.3-rc.1+build.5 x", `ok "1.2.3-rc.1+build.5" 1.2.3-rc.1+build.5 @18`},
+		{SemVer, "0.0.0-0.a-b", `ok "0.0.0-0.a-b" 0.0.0-0.a-b @11`},
+		{SemVer, "1.2.3+001", `ok "1.2.3+001" 1.2.3+001 @9`},
+		{SemVer, "1.2", `incomplete "1.2" @3`},
+		{SemVer, "1.2.", `incomplete "1.2." @4`},
+		{SemVer, "1.2.3-", `incomplete "1.2.3-" @6`},
+		{SemVer, "1.2.3-rc..1", `invalid "1.2.3-rc." @9`},
+		{SemVer, "01.2.3", `invalid "01" @2`},
+		{SemVer, "1.2.3-01", `invalid "1.2.3-01" @8`},
+		{SemVer, "1.2.18446744073709551616", `overflow "1.2.18446744073709551616" @24`},
+		{SemVer, "v1.2.3", `unmatched "" @0`},
+
+		{VersionConstraint, "1.2.3", `ok "1.2.3" =1.2.3 @5`},
+		{VersionConstraint, "1.2", `ok "1.2" >=1.2.0 <1.3.0 @3`},
+		{VersionConstraint, "1.x", `ok "1.x" >=1.0.0 <2.0.0 @3`},
+		{VersionConstraint, "1.2.*", `ok "1.2.*" >=1.2.0 <1.3.0 @5`},
+		{VersionConstraint, "*", `ok "*" * @1`},
+		{VersionConstraint, "*.*", `ok "*.*" * @3`},
+		{VersionConstraint, "1.X", `ok "1.X" >=1.0.0 <2.0.0 @3`},
+		{VersionConstraint, "1.X.*", `ok "1.X.*" >=1.0.0 <2.0.0 @5`},
+		{VersionConstraint, "1.*.X", `ok "1.*.X" >=1.0.0 <2.0.0 @5`},
+		{VersionConstraint, "1.x.x", `ok "1.x.x" >=1.0.0 <2.0.0 @5`},
+		{VersionConstraint, "1.2.X", `ok "1.2.X" >=1.2.0 <1.3.0 @5`},
+		{VersionConstraint, "X", `unmatched "" @0`},
+		{VersionConstraint, "=1.2 !=1.2.3", `ok "=1.2 !=1.2.3" >=1.2.0 <1.3.0 !=1.2.3 @12`},
+		{VersionConstraint, ">1.2", `ok ">1.2" >=1.3.0 @4`},
+		{VersionConstraint, "<=1.2", `ok "<=1.2" <1.3.0 @5`},
+		{VersionConstraint, "^1.2.3", `ok "^1.2.3" >=1.2.3 <2.0.0 @6`},
+		{VersionConstraint, "^0.2.3", `ok "^0.2.3" >=0.2.3 <0.3.0 @6`},
+		{VersionConstraint, "^0.0.3", `ok "^0.0.3" >=0.0.3 <0.0.4 @6`},
+		{VersionConstraint, "^0", `ok "^0" >=0.0.0 <1.0.0 @2`},
+		{VersionConstraint, "~1.4", `ok "~1.4" >=1.4.0 <1.5.0 @4`},
+		{VersionConstraint, "~1.4.2", `ok "~1.4.2" >=1.4.2 <1.5.0 @6`},
+		{VersionConstraint, "~1", `ok "~1" >=1.0.0 <2.0.0 @2`},
+		{VersionConstraint, ">= 1.0, <2.0", `ok ">= 1.0, <2.0" >=1.0.0 <2.0.0 @12`},
+		{VersionConstraint, ">=1.0 <2.0", `ok ">=1.0 <2.0" >=1.0.0 <2.0.0 @10`},
+		{VersionConstraint, "^1 || ^2", `ok "^1 || ^2" >=1.0.0 <2.0.0 || >=2.0.0 <3.0.0 @8`},
+		{VersionConstraint, "1.2.3, x", `ok "1.2.3" =1.2.3 @5`},
+		{VersionConstraint, "1.2.3 ,", `ok "1.2.3" =1.2.3 @5`},
+		{VersionConstraint, "1.2.3 x", `ok "1.2.3" =1.2.3 @5`},
+		{VersionConstraint, "1.2.3 | 2", `ok "1.2.3" =1.2.3 @5`},
+		{VersionConstraint, "1.2.3\t", `ok "1.2.3" =1.2.3 @5`},
+		{VersionConstraint, "^1 ||", `incomplete "^1 ||" @5`},
+		{VersionConstraint, ">=", `incomplete ">=" @2`},
+		{VersionConstraint, "!=1.2", `invalid "!=1.2" @5`},
+		{VersionConstraint, "x", `unmatched "" @0`},
+		{VersionConstraint, "X.1", `unmatched "" @0`},
+	}
+	for _, tt := range tests {
+		if got := run_term(tt.term, tt.input); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	parse := func(s string) Version {
+		ctx := Context{}
+		if ec := SemVer(Static([]byte(s), nil), &ctx); ec != ErrCodeNone {
+			t.Fatalf("SemVer(%q) = %v", s, ec)
+		}
+		return ctx.Values[0].(Version)
+	}
+	// in the order of precedence, see the SemVer specification
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1",
+		"1.1.0", "2.0.0",
+	}
+	for i, a := range ordered {
+		for j, b := range ordered {
+			want := cmp_uint(uint64(i), uint64(j))
+			if got := parse(a).Compare(parse(b)); got != want {
+				t.Errorf("%s.Compare(%s) = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+	if got := parse("1.0.0+a").Compare(parse("1.0.0+b")); got != 0 {
+		t.Errorf("build metadata: Compare() = %d, want 0", got)
+	}
+
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"^1.2.3", "1.2.3", true},
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "2.0.0", false},
+		{"^1.2.3", "1.2.2", false},
+		{"~1.4", "1.4.9", true},
+		{"~1.4", "1.5.0", false},
+		{">=1.0 <2.0", "1.5.0", true},
+		{">=1.0 <2.0", "2.0.0", false},
+		{">=1.0 <2.0", "2.0.0-rc.1", true},
+		{"^1 || ^3", "2.1.0", false},
+		{"^1 || ^3", "3.1.0", true},
+		{"*", "0.0.1", true},
+		{"!=1.2.3", "1.2.3", false},
+	}
+	for _, tt := range tests {
+		ctx := Context{}
+		if ec := VersionConstraint(Static([]byte(tt.constraint), nil), &ctx); ec != ErrCodeNone {
+			t.Fatalf("VersionConstraint(%q) = %v", tt.constraint, ec)
+		}
+		if got := ctx.Values[0].(Constraint).Check(parse(tt.version)); got != tt.want {
+			t.Errorf("%q.Check(%s) = %v, want %v", tt.constraint, tt.version, got, tt.want)
+		}
+	}
+}
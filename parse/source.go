@@ -1,10 +1,20 @@
 package parse
 
 import (
-	"strings"
+	"bytes"
+	"context"
+	"math"
+	"unicode/utf16"
 	"unicode/utf8"
 )
 
+// Source is the input that terms read from.
+//
+// Only the sources created with Static and StaticWith, which include the
+// sources used by the Tokenize functions, apply Options. Terms read other
+// implementations as they are: the encoding, invalid sequence policy, column
+// mode, line breaks and LineIndex are up to the implementation, and the
+// terms do not check Limits or cancellation and do not use Memo with them.
 type Source interface {
 	// Done indicates that there is no more content available in the input.
 	Done() bool
@@ -30,27 +40,192 @@ type Source interface {
 
 const Unmatched = rune(0x7fffffff)
 
+// Positioned is implemented by sources that can report their current
+// position.
+type Positioned interface {
+	Location() Location
+	LineCol() LineCol
+}
+
+// Rewinder is implemented by sources that can return to an earlier
+// position, such as the ones created with Static and StaticWith.
+//
+// Terms that need to look further ahead than Peek, such as IPv6, CIDR, URI
+// and VersionConstraint, rewind these sources when the content turns out
+// not to match, and leave it unconsumed. Sources that do not implement
+// Rewinder cannot give the content back, so these terms consume it: words
+// that turn out not to match are reported as invalid, and trailing
+// punctuation and separators are consumed along with the match.
+type Rewinder interface {
+	// Mark returns the current position.
+	Mark() any
+
+	// Rewind returns to a position obtained with Mark.
+	Rewind(mark any)
+}
+
 type static_impl struct {
-	buf              []byte
-	pos              int
-	end              int
-	panic_on_invalid bool
-	loc              *LineCol
+	buf       []byte
+	pos       int
+	end       int
+	invalid   InvalidPolicy
+	enc       Encoding
+	columns   ColumnMode
+	tab_width int
+	breaks    LineBreaks
+	normalize bool
+	plain     bool // UTF-8 without normalization, enables ASCII fast paths
+	after_cr  bool
+	cluster   cluster_state // for ColumnVisual, see visual_width
+	line_pos  int           // offset of the current line start
+	lines     *LineIndex
+	err       error
+	loc       *LineCol
+	memo      *Memo
+
+	// limits and cancellation, see watched
+	limits      Limits
+	ctx         context.Context
+	watch       int // offset past which check runs
+	steps       int // invocations left before check runs, see tick
+	token_start int
+	depth       int // nesting of Recursive terms
 }
 
 // Static implements Source that reads content from memory-loaded data.
 func Static(buf []byte, lc *LineCol) *static_impl {
-	return &static_impl{
-		buf: buf,
-		end: len(buf),
-		loc: lc,
+	return StaticWith(buf, lc, nil)
+}
+
+// StaticWith is the same as Static, but takes configuration options.
+func StaticWith(buf []byte, lc *LineCol, opts *Options) *static_impl {
+	r := &static_impl{
+		buf:   buf,
+		end:   len(buf),
+		loc:   lc,
+		watch: math.MaxInt,
 	}
+	if opts != nil {
+		r.invalid = opts.Invalid
+		r.enc = opts.Encoding
+		r.columns = opts.Columns
+		r.tab_width = opts.TabWidth
+		r.breaks = opts.LineBreaks
+		r.normalize = opts.NormalizeNewlines
+		r.lines = opts.LineIndex
+		r.limits = opts.Limits
+		r.memo = opts.Memo
+		if r.lines != nil {
+			r.lines.reset(buf)
+		}
+		if r.memo != nil {
+			r.memo.reset()
+		}
+		if r.enc == EncodingAuto {
+			r.enc, r.pos = DetectEncoding(buf)
+		}
+	}
+	if r.tab_width <= 0 {
+		r.tab_width = 8
+	}
+	if r.breaks == 0 {
+		r.breaks = BreakLF
+	}
+	if r.loc == nil {
+		r.loc = &LineCol{}
+	}
+	r.line_pos = r.pos
+	r.plain = r.enc == EncodingUTF8 && !r.normalize
+	r.set_watch()
+	return r
 }
 
 func (r *static_impl) Offset() int {
 	return r.pos
 }
 
+func (r *static_impl) LineCol() LineCol {
+	return *r.loc
+}
+
+func (r *static_impl) Location() Location {
+	return Location{Offset: r.pos, LineNumber: r.loc.LineIndex + 1, LineOffset: r.line_pos}
+}
+
+// static_state is a snapshot of the reading position, see state and
+// restore.
+type static_state struct {
+	pos        int
+	loc        LineCol
+	line_pos   int
+	after_cr   bool
+	cluster    cluster_state
+	n_lines    int
+	last_start int
+}
+
+func (r *static_impl) state() static_state {
+	st := static_state{pos: r.pos, loc: *r.loc, line_pos: r.line_pos, after_cr: r.after_cr, cluster: r.cluster}
+	if r.lines != nil {
+		st.n_lines = len(r.lines.starts)
+		st.last_start = r.lines.starts[st.n_lines-1]
+	}
+	return st
+}
+
+func (r *static_impl) Mark() any {
+	return r.state()
+}
+
+func (r *static_impl) Rewind(mark any) {
+	r.restore(mark.(static_state))
+}
+
+// restore moves the reading position back to the state taken earlier.
+func (r *static_impl) restore(st static_state) {
+	r.pos, *r.loc, r.line_pos, r.after_cr, r.cluster = st.pos, st.loc, st.line_pos, st.after_cr, st.cluster
+	if r.lines != nil {
+		r.lines.starts = r.lines.starts[:st.n_lines]
+		r.lines.starts[st.n_lines-1] = st.last_start
+	}
+}
+
+// seek moves the reading position to a location obtained from an earlier
+// pass over the same content, see Retokenize.
+func (r *static_impl) seek(at Location, lc LineCol) {
+	r.pos, r.line_pos, *r.loc = at.Offset, at.LineOffset, lc
+	r.after_cr = false
+	r.cluster = cluster_none
+	if r.breaks.has('\r') && !r.normalize {
+		// the code unit before, which is a CR if the previous line ended
+		// with one
+		unit := 1
+		switch r.enc {
+		case EncodingUTF16LE, EncodingUTF16BE:
+			unit = 2
+		case EncodingUTF32LE, EncodingUTF32BE:
+			unit = 4
+		}
+		if r.pos >= unit {
+			c, size, _ := r.decode_raw(r.pos - unit)
+			r.after_cr = c == '\r' && size == unit
+		}
+	}
+}
+
+// Err returns the error that stopped the source, if any.
+func (r *static_impl) Err() error {
+	return r.err
+}
+
+// fail records the error at the current location and makes the source
+// appear exhausted, so that any term in progress stops.
+func (r *static_impl) fail(err error) {
+	at := r.Location()
+	r.err = &ErrAtLineCol{Err: err, Loc: *r.loc, Span: Span{at, at}}
+	r.end = r.pos
+}
+
 func (r *static_impl) Done() bool {
 	return r.pos >= r.end
 }
@@ -59,26 +234,170 @@ func (r *static_impl) next() (c rune, size int) {
 	if r.pos >= r.end {
 		return 0, 0
 	}
-	c, size = rune(r.buf[r.pos]), 1
-	if c >= utf8.RuneSelf {
-		c, size = utf8.DecodeRune(r.buf[r.pos:])
-		if size < 2 {
-			// invalid codepoint
-			if r.panic_on_invalid {
-				panic(Invalid("utf-8 sequence"))
-			}
+	c, size, ok := r.decode(r.pos)
+	if !ok {
+		r.invalid_at(r.pos)
+		return 0, 0
+	}
+	return c, size
+}
+
+// invalid_at applies the InvalidError or InvalidPanic policy to the invalid
+// sequence at the given offset. The valid content before it is consumed, so
+// that the error is located at the sequence.
+func (r *static_impl) invalid_at(at int) {
+	if r.invalid == InvalidPanic {
+		panic(Invalid(r.enc.String() + " sequence"))
+	}
+	r.advance_to(at)
+	r.fail(Invalid(r.enc.String() + " sequence"))
+}
 
-			// zip through the rest of the invalids
-			for r.pos+size < r.end && ((r.buf[r.pos+size] & 0b11000000) == 0b10000000) {
-				size++
+// decode returns the codepoint at the given offset. For invalid sequences,
+// it applies the invalid policy; ok is false if the policy does not provide
+// a replacement.
+func (r *static_impl) decode(at int) (c rune, size int, ok bool) {
+	c, size, ok = r.decode_raw(at)
+	if r.normalize && c == '\r' {
+		if at+size < r.end {
+			if c2, size2, _ := r.decode_raw(at + size); c2 == '\n' {
+				size += size2
 			}
-			return utf8.RuneError, size
 		}
+		c = '\n'
 	}
-	return c, size
+	return
+}
+
+func (r *static_impl) decode_raw(at int) (c rune, size int, ok bool) {
+	switch r.enc {
+	case EncodingUTF16LE, EncodingUTF16BE:
+		return r.decode_utf16(at)
+	case EncodingUTF32LE, EncodingUTF32BE:
+		return r.decode_utf32(at)
+	case EncodingLatin1:
+		return rune(r.buf[at]), 1, true
+	}
+
+	c, size = rune(r.buf[at]), 1
+	if c < utf8.RuneSelf {
+		return c, size, true
+	}
+	c, size = utf8.DecodeRune(r.buf[at:r.end])
+	if size >= 2 {
+		return c, size, true
+	}
+
+	// invalid codepoint
+	switch r.invalid {
+	case InvalidLatin1:
+		return rune(r.buf[at]), 1, true
+	case InvalidReplace:
+		// zip through the rest of the invalids
+		for at+size < r.end && ((r.buf[at+size] & 0b11000000) == 0b10000000) {
+			size++
+		}
+		return utf8.RuneError, size, true
+	default:
+		return 0, 0, false
+	}
+}
+
+func (r *static_impl) unit16(at int) rune {
+	if r.enc == EncodingUTF16LE {
+		return rune(r.buf[at]) | rune(r.buf[at+1])<<8
+	}
+	return rune(r.buf[at])<<8 | rune(r.buf[at+1])
+}
+
+func (r *static_impl) decode_utf16(at int) (c rune, size int, ok bool) {
+	if at+2 > r.end {
+		return r.invalid_unit(r.end - at)
+	}
+	c = r.unit16(at)
+	if !utf16.IsSurrogate(c) {
+		return c, 2, true
+	}
+	if c < 0xDC00 && at+4 <= r.end {
+		if c = utf16.DecodeRune(c, r.unit16(at+2)); c != utf8.RuneError {
+			return c, 4, true
+		}
+	}
+	return r.invalid_unit(2)
+}
+
+func (r *static_impl) decode_utf32(at int) (c rune, size int, ok bool) {
+	if at+4 > r.end {
+		return r.invalid_unit(r.end - at)
+	}
+	b := r.buf[at : at+4]
+	if r.enc == EncodingUTF32LE {
+		c = rune(b[0]) | rune(b[1])<<8 | rune(b[2])<<16 | rune(b[3])<<24
+	} else {
+		c = rune(b[0])<<24 | rune(b[1])<<16 | rune(b[2])<<8 | rune(b[3])
+	}
+	if !utf8.ValidRune(c) {
+		return r.invalid_unit(4)
+	}
+	return c, 4, true
+}
+
+// invalid_unit applies the invalid policy to a UTF-16 or UTF-32 code unit.
+func (r *static_impl) invalid_unit(size int) (rune, int, bool) {
+	if r.invalid == InvalidReplace || r.invalid == InvalidLatin1 {
+		return utf8.RuneError, size, true
+	}
+	return 0, 0, false
+}
+
+// match checks if the content at the current position matches seq and
+// returns the offset right after the matched content. An invalid sequence
+// in the compared content is handled according to the invalid policy, as
+// with the other reads.
+func (r *static_impl) match(seq string) (end int, ok bool) {
+	if r.plain {
+		end = r.pos + len(seq)
+		if end <= r.end && string(r.buf[r.pos:end]) == seq {
+			return end, true
+		}
+		// the codepoint at which the content differs from seq
+		at := r.pos
+		for at < r.end && at-r.pos < len(seq) && r.buf[at] == seq[at-r.pos] {
+			at++
+		}
+		for at > r.pos && (at == r.end || !utf8.RuneStart(r.buf[at])) {
+			at--
+		}
+		if at == r.end || r.buf[at] < utf8.RuneSelf {
+			return 0, false
+		}
+		if _, size := utf8.DecodeRune(r.buf[at:r.end]); size >= 2 {
+			return 0, false
+		}
+		// the replacement of the invalid sequence may still match
+	}
+	end = r.pos
+	for _, want := range seq {
+		if end >= r.end {
+			return 0, false
+		}
+		c, size, ok := r.decode(end)
+		if !ok {
+			r.invalid_at(end)
+			return 0, false
+		}
+		if c != want {
+			return 0, false
+		}
+		end += size
+	}
+	return end, true
 }
 
 func (r *static_impl) Peek() rune {
+	if r.plain && r.pos < r.end && r.buf[r.pos] < utf8.RuneSelf {
+		return rune(r.buf[r.pos])
+	}
 	c, sz := r.next()
 	if sz > 0 {
 		return c
@@ -88,55 +407,46 @@ func (r *static_impl) Peek() rune {
 }
 
 func (r *static_impl) Hop(c rune) bool {
+	if r.plain && c < utf8.RuneSelf && r.pos < r.watch && (r.pos >= r.end || r.buf[r.pos] < utf8.RuneSelf) {
+		if r.pos >= r.end || r.buf[r.pos] != byte(c) {
+			return false
+		}
+		r.step_ascii(c)
+		return true
+	}
 	have, sz := r.next()
 	if sz == 0 || c != have {
 		return false
 	}
+	r.step(c, sz)
 	r.pos += sz
-	if r.loc != nil {
-		if c == '\n' {
-			r.loc.LineIndex++
-			r.loc.ColumnIndex = 0
-		} else {
-			r.loc.ColumnIndex++
-		}
-	}
+	r.watched()
 	return true
 }
 
 func (r *static_impl) Leap(seq string) bool {
-	n := len(seq)
-	ok := r.pos+n <= r.end && string(r.buf[r.pos:r.pos+n]) == seq
+	end, ok := r.match(seq)
 	if ok {
-		r.pos += n
-		if r.loc != nil {
-			for {
-				if i := strings.IndexByte(seq, '\n'); i >= 0 {
-					r.loc.LineIndex++
-					r.loc.ColumnIndex = 0
-					seq = seq[i+1:]
-				} else {
-					break
-				}
-			}
-			r.loc.ColumnIndex += utf8.RuneCountInString(seq)
-		}
+		r.advance_to(end)
 	}
 	return ok
 }
 
 func (r *static_impl) Fetch(f func(rune) bool) rune {
+	if r.plain && r.pos < r.end && r.pos < r.watch && r.buf[r.pos] < utf8.RuneSelf {
+		// the position cannot pass the watch offset here
+		c := rune(r.buf[r.pos])
+		if f != nil && !f(c) {
+			return Unmatched
+		}
+		r.step_ascii(c)
+		return c
+	}
 	c, size := r.next()
 	if size > 0 && (f == nil || f(c)) {
+		r.step(c, size)
 		r.pos += size
-		if r.loc != nil {
-			if c == '\n' {
-				r.loc.LineIndex++
-				r.loc.ColumnIndex = 0
-			} else {
-				r.loc.ColumnIndex++
-			}
-		}
+		r.watched()
 		return c
 	} else {
 		return Unmatched
@@ -144,42 +454,181 @@ func (r *static_impl) Fetch(f func(rune) bool) rune {
 }
 
 func (r *static_impl) Skip(seq string, term func(rune) bool) rune {
-	n := len(seq)
-	if n == 0 {
+	if len(seq) == 0 {
 		return r.Fetch(term)
 	}
-	seq_ok := r.pos+n < r.end && string(r.buf[r.pos:r.pos+n]) == seq
-	if !seq_ok {
+	seq_end, seq_ok := r.match(seq)
+	if !seq_ok || seq_end >= r.end {
 		return Unmatched
 	}
-	t, t_size := rune(r.buf[r.pos+n]), 1
-	if t >= utf8.RuneSelf {
-		t, t_size = utf8.DecodeRune(r.buf[r.pos+n:])
-		if t_size < 2 {
-			return Unmatched
-		}
+	t, t_size, t_ok := r.decode(seq_end)
+	if !t_ok {
+		r.invalid_at(seq_end)
+		return Unmatched
 	}
 	if !term(t) {
 		return Unmatched
 	}
-	r.pos += n + t_size
-	if r.loc != nil {
-		for {
-			if i := strings.IndexByte(seq, '\n'); i >= 0 {
-				r.loc.LineIndex++
-				r.loc.ColumnIndex = 0
-				seq = seq[i+1:]
-			} else {
-				break
+	r.advance_to(seq_end + t_size)
+	return t
+}
+
+// step_ascii consumes an ASCII codepoint c in plain UTF-8 input.
+func (r *static_impl) step_ascii(c rune) {
+	if c >= ' ' && c < 0x7f {
+		// printable characters occupy one column in all modes
+		r.after_cr = false
+		r.cluster = cluster_none
+		r.loc.ColumnIndex++
+	} else {
+		r.step(c, 1)
+	}
+	r.pos++
+}
+
+// step updates the location for a consumed codepoint c encoded with size
+// bytes.
+func (r *static_impl) step(c rune, size int) {
+	after_cr := r.after_cr
+	r.after_cr = false
+	if r.breaks.has(c) {
+		if c == '\n' && after_cr {
+			// the second half of CRLF
+			r.line_pos = r.pos + size
+			if r.lines != nil {
+				r.lines.starts[len(r.lines.starts)-1] = r.line_pos
 			}
+			return
 		}
-		r.loc.ColumnIndex += utf8.RuneCountInString(seq)
-		if t == '\n' {
-			r.loc.LineIndex++
-			r.loc.ColumnIndex = 0
+		r.line_pos = r.pos + size
+		if r.lines != nil {
+			r.lines.add(r.line_pos)
+		}
+		r.loc.LineIndex++
+		r.loc.ColumnIndex = 0
+		r.after_cr = c == '\r'
+		r.cluster = cluster_none
+		return
+	}
+	switch r.columns {
+	case ColumnBytes:
+		r.loc.ColumnIndex += size
+	case ColumnUTF16:
+		if c >= 0x10000 && c != utf8.RuneError {
+			r.loc.ColumnIndex += 2
 		} else {
 			r.loc.ColumnIndex++
 		}
+	case ColumnVisual:
+		if c == '\t' {
+			r.loc.ColumnIndex += r.tab_width - r.loc.ColumnIndex%r.tab_width
+			r.cluster = cluster_none
+		} else {
+			r.loc.ColumnIndex += r.visual_width(c)
+		}
+	default:
+		r.loc.ColumnIndex++
+	}
+}
+
+// advance_to consumes the content up to the end offset, which must be at a
+// codepoint boundary.
+func (r *static_impl) advance_to(end int) {
+	if r.lines == nil && r.columns == ColumnCodepoints && r.enc == EncodingUTF8 && !r.normalize && r.breaks == BreakLF {
+		seq := r.buf[r.pos:end]
+		if i := bytes.LastIndexByte(seq, '\n'); i >= 0 {
+			r.loc.LineIndex += bytes.Count(seq[:i+1], []byte{'\n'})
+			r.loc.ColumnIndex = 0
+			r.line_pos = r.pos + i + 1
+			seq = seq[i+1:]
+		}
+		r.loc.ColumnIndex += utf8.RuneCount(seq)
+		r.pos = end
+		r.watched()
+		return
+	}
+	for r.pos < end {
+		if r.plain && r.buf[r.pos] < utf8.RuneSelf {
+			r.step_ascii(rune(r.buf[r.pos]))
+			continue
+		}
+		c, size, _ := r.decode(r.pos)
+		r.step(c, size)
+		r.pos += size
+	}
+	r.watched()
+}
+
+// check_interval is the number of bytes consumed between the checks for
+// cancellation.
+const check_interval = 16 << 10
+
+// watched enforces the limits and checks for cancellation once the position
+// passes the watch offset. It must be called after consuming content, as it
+// may stop the source at the current position.
+func (r *static_impl) watched() {
+	if r.pos > r.watch {
+		r.check()
+	}
+}
+
+// check_steps is the number of term invocations between the checks for
+// cancellation.
+const check_steps = 1 << 10
+
+// tick counts an invocation of a term that may try content without
+// consuming it, such as FirstOf, Recursive or Memoize, so that cancellation
+// also interrupts grammars that try many alternatives at the same position.
+// It reports whether the source is stopped, in which case the term must
+// return ErrCodeUnmatched without trying anything.
+func (r *static_impl) tick() bool {
+	if r.steps--; r.steps < 0 {
+		r.steps = check_steps
+		if r.ctx != nil {
+			r.check()
+		}
+	}
+	return r.err != nil
+}
+
+func (r *static_impl) check() {
+	if r.err != nil {
+		return
+	}
+	if r.ctx != nil && r.ctx.Err() != nil {
+		r.fail(r.ctx.Err())
+		return
+	}
+	if max := r.limits.MaxTokenLen; max > 0 && r.pos-r.token_start > max {
+		r.fail(ErrTokenLength)
+		return
+	}
+	r.set_watch()
+}
+
+func (r *static_impl) set_watch() {
+	r.watch = math.MaxInt
+	if r.ctx != nil {
+		r.watch = r.pos + check_interval
+	}
+	if max := r.limits.MaxTokenLen; max > 0 && r.token_start+max < r.watch {
+		r.watch = r.token_start + max
+	}
+}
+
+// watch_context makes the source stop with ctx.Err() when ctx is canceled.
+func (r *static_impl) watch_context(ctx context.Context) {
+	if ctx.Done() != nil {
+		r.ctx = ctx
+		r.set_watch()
+	}
+}
+
+// start_token marks the start of a token for Limits.MaxTokenLen and Memo,
+// and checks for cancellation.
+func (r *static_impl) start_token() {
+	r.token_start = r.pos
+	if r.ctx != nil || r.limits.MaxTokenLen > 0 {
+		r.check()
 	}
-	return t
 }
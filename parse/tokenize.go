@@ -1,6 +1,12 @@
 package parse
 
-import "strings"
+import (
+	"context"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"unsafe"
+)
 
 type Key = any
 
@@ -18,37 +24,140 @@ func Bind[K Key](key K, descr string, sequence ...any) *Binding[K] {
 	}
 }
 
+// Tokenize splits buf into tokens: at each position, the bindings are tried
+// in order and on_token is called for the first one that matches.
+//
+// When no binding matches, the bindings are matched once more at that
+// position to collect what they expected for the error message. Terms must
+// therefore have the same outcome when they are called again at the same
+// position, and custom terms should not have side effects other than on the
+// source and the context. The replay repeats the work of Recursive terms,
+// and of Memoize terms as well, as the Memo is bypassed.
 func Tokenize[T Key](buf []byte, bindings []*Binding[T], on_token func(k T, c *Context, lc LineCol)) error {
-	lc := LineCol{}
-	src := Static(buf, &lc)
+	return TokenizeWith(buf, bindings, on_token, nil)
+}
+
+// TokenizeWith is the same as Tokenize, but takes configuration options.
+func TokenizeWith[T Key](buf []byte, bindings []*Binding[T], on_token func(k T, c *Context, lc LineCol), opts *Options) error {
+	return tokenize(StaticWith(buf, nil, opts), bindings, on_token)
+}
+
+// TokenizeContext is the same as TokenizeWith, but stops when ctx is
+// canceled and returns ctx.Err(). Cancellation is checked between tokens,
+// periodically while matching long tokens, and every so many invocations of
+// FirstOf, Recursive and Memoize terms, so that grammars that keep trying
+// alternatives without consuming content are interrupted as well. Along
+// with opts.Limits, this bounds the time spent on hostile input.
+func TokenizeContext[T Key](ctx context.Context, buf []byte, bindings []*Binding[T], on_token func(k T, c *Context, lc LineCol), opts *Options) error {
+	return tokenize_context(ctx, StaticWith(buf, nil, opts), bindings, on_token)
+}
+
+func tokenize_context[T Key](ctx context.Context, src *static_impl, bindings []*Binding[T], on_token func(k T, c *Context, lc LineCol)) error {
+	src.watch_context(ctx)
+	err := tokenize(src, bindings, on_token)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// tokenize matches the bindings from the current position of src to its end.
+func tokenize[T Key](src *static_impl, bindings []*Binding[T], on_token func(k T, c *Context, lc LineCol)) error {
 	var ec ErrCode
 	ctx := Context{}
+	ctx.Attach(src)
+	n := 0
 
 outer:
-	for !src.Done() {
-		lc_orig := lc
+	for {
+		src.start_token()
+		if src.Done() {
+			break
+		}
+		lc_orig := *src.loc
+		start := src.Location()
+		state := src.state()
 		for _, binding := range bindings {
 			ctx.Reset()
 			ec = binding.c(src, &ctx)
 			if ec == ErrCodeUnmatched {
 				continue
 			}
+			if err := src.Err(); err != nil {
+				return err
+			}
+			ctx.Span = Span{start, src.Location()}
 			if ec != ErrCodeNone {
-				err := &ErrContent{Code: ec, What: binding.descr}
-				return &ErrAtLineCol{Err: err, Loc: lc_orig}
+				err := &ErrContent{Code: ec, What: binding.descr, Hint: ctx.failure.hint}
+				loc := lc_orig
+				if ctx.failure.what != "" {
+					err.What = ctx.failure.what
+				}
+				if ctx.failure.has_loc {
+					loc = ctx.failure.loc
+					ctx.Span.Start = ctx.failure.at
+				}
+				return &ErrAtLineCol{Err: err, Loc: loc, Span: ctx.Span}
+			}
+			if n++; src.limits.MaxTokens > 0 && n > src.limits.MaxTokens {
+				return &ErrAtLineCol{Err: ErrTokenCount, Loc: lc_orig, Span: ctx.Span}
 			}
 			on_token(binding.k, &ctx, lc_orig)
 			continue outer
 		}
-		err := &ErrContent{ErrCodeUnexpected, "content"}
-		return &ErrAtLineCol{Err: err, Loc: lc_orig}
+		if err := src.Err(); err != nil {
+			return err
+		}
+
+		// Nothing matched. Tracking the expectations slows down matching
+		// considerably, so it is only done now, by replaying the bindings.
+		src.restore(state)
+		ctx.track = &expectations{}
+		ctx.track.reset()
+		for _, binding := range bindings {
+			ctx.Reset()
+			n := ctx.track.mark(start.Offset)
+			if binding.c(src, &ctx) == ErrCodeUnmatched && binding.descr != "" {
+				// the description replaces whatever the binding
+				// expected at its starting position
+				ctx.track.replace(start, lc_orig, n, binding.descr)
+			}
+		}
+		if len(ctx.track.items) > 0 {
+			err := &ErrContent{Code: ErrCodeExpected, What: ctx.track.String()}
+			return &ErrAtLineCol{Err: err, Loc: ctx.track.loc, Span: Span{ctx.track.at, ctx.track.at}}
+		}
+		err := &ErrContent{Code: ErrCodeUnexpected, What: "content"}
+		return &ErrAtLineCol{Err: err, Loc: lc_orig, Span: Span{start, start}}
 	}
-	return nil
+	return src.Err()
 }
 
 type Context struct {
 	strings.Builder
 	Values []any
+
+	// Span is the content matched by the token, set by Tokenize.
+	Span Span
+
+	muted   int
+	track   *expectations
+	failure failure
+
+	// zero-copy capture, see Attach
+	in         *static_impl
+	view_start int
+	view_end   int
+}
+
+// failure holds the custom description of a failed term, see Labeled and
+// Cut.
+type failure struct {
+	what    string
+	hint    string
+	at      Location
+	loc     LineCol
+	has_loc bool
 }
 
 type TermFunc = func(Source, *Context) ErrCode
@@ -60,4 +169,218 @@ type Term interface {
 func (c *Context) Reset() {
 	c.Builder.Reset()
 	c.Values = c.Values[:0]
+	c.failure = failure{}
+	c.view_start, c.view_end = 0, 0
+}
+
+// Attach enables zero-copy capturing for content consumed from src, which
+// must be created with Static or StaticWith; other sources are ignored.
+// Tokenize attaches its source automatically.
+//
+// While the captured text is a verbatim copy of the consumed input, it is
+// kept as a subslice of the input buffer instead of being written to the
+// builder, and Bytes returns it without copying. Terms that write anything
+// else, such as decoded escape sequences, switch the context to the regular
+// builder. String always returns a copy.
+func (c *Context) Attach(src Source) {
+	c.in, _ = src.(*static_impl)
+	c.view_start, c.view_end = 0, 0
+}
+
+// Len returns the number of bytes captured.
+func (c *Context) Len() int {
+	if c.view_end > c.view_start {
+		return c.view_end - c.view_start
+	}
+	return c.Builder.Len()
+}
+
+// String returns the captured string.
+func (c *Context) String() string {
+	if c.view_end > c.view_start {
+		return string(c.in.buf[c.view_start:c.view_end])
+	}
+	return c.Builder.String()
+}
+
+// Bytes returns the captured content. When the content is a verbatim copy of
+// the input, see Attach, it is returned as a subslice of the input buffer:
+// it must not be modified, and it changes if the buffer does. Use String to
+// keep the content.
+func (c *Context) Bytes() []byte {
+	if c.view_end > c.view_start {
+		return c.in.buf[c.view_start:c.view_end:c.view_end]
+	}
+	return []byte(c.Builder.String())
+}
+
+// text returns the captured string without copying it from the input
+// buffer, for use while the buffer is being tokenized.
+func (c *Context) text() string {
+	if c.view_end > c.view_start {
+		b := c.in.buf[c.view_start:c.view_end]
+		return *(*string)(unsafe.Pointer(&b))
+	}
+	return c.Builder.String()
+}
+
+// Write, WriteByte, WriteRune, and WriteString append to the captured string
+// unless capturing is suspended, see Skip.
+
+func (c *Context) Write(p []byte) (int, error) {
+	if c.muted > 0 || capture_view(c, p) {
+		return len(p), nil
+	}
+	return c.Builder.Write(p)
+}
+
+func (c *Context) WriteByte(b byte) error {
+	if c.muted > 0 || capture_view(c, []byte{b}) {
+		return nil
+	}
+	return c.Builder.WriteByte(b)
+}
+
+func (c *Context) WriteRune(r rune) (int, error) {
+	if c.muted > 0 {
+		return 0, nil
+	}
+	if c.in != nil && c.Builder.Len() == 0 {
+		buf := [utf8.UTFMax]byte{}
+		n := utf8.EncodeRune(buf[:], r)
+		if capture_view(c, buf[:n]) {
+			return n, nil
+		}
+	}
+	return c.Builder.WriteRune(r)
+}
+
+func (c *Context) WriteString(s string) (int, error) {
+	if c.muted > 0 || capture_view(c, s) {
+		return len(s), nil
+	}
+	return c.Builder.WriteString(s)
+}
+
+// capture_view extends the zero-copy view with s if s is the content that
+// was consumed right before the current position of the attached source.
+// Otherwise, it moves the view into the builder and returns false.
+func capture_view[T string | []byte](c *Context, s T) bool {
+	if c.in == nil || c.Builder.Len() > 0 {
+		return false
+	}
+	end := c.in.pos
+	start := end - len(s)
+	if start >= 0 && (c.view_end == c.view_start || c.view_end == start) &&
+		string(c.in.buf[start:end]) == string(s) {
+		if c.view_end == c.view_start {
+			c.view_start = start
+		}
+		c.view_end = end
+		return true
+	}
+	if c.view_end > c.view_start {
+		c.Builder.Write(c.in.buf[c.view_start:c.view_end])
+		c.view_start, c.view_end = 0, 0
+	}
+	return false
+}
+
+// muted runs term with capturing suspended.
+func muted(term TermFunc, src Source, ctx *Context) ErrCode {
+	if ctx == nil {
+		return term(src, nil)
+	}
+	ctx.muted++
+	ec := term(src, ctx)
+	ctx.muted--
+	return ec
+}
+
+// Expect records that the term described by what did not match at the
+// current position of src. Along with the binding descriptions, these are
+// used by Tokenize to produce messages like `expected ')', ',' or ident`
+// for the furthest position at which matching failed.
+//
+// Calling Expect is only necessary in custom terms; the built-in literal
+// terms already record their expectations.
+func (c *Context) Expect(src Source, what string) {
+	if c == nil || c.track == nil {
+		return
+	}
+	if s, ok := src.(*static_impl); ok && s.pos < c.track.at.Offset {
+		// behind the furthest failure
+		return
+	}
+	if p, ok := src.(Positioned); ok {
+		c.track.add(p.Location(), p.LineCol(), what)
+	}
+}
+
+// expectations keeps the descriptions of terms that failed to match at the
+// furthest position reached so far.
+type expectations struct {
+	at    Location
+	loc   LineCol
+	items []string
+}
+
+func (e *expectations) reset() {
+	e.at.Offset = -1
+	e.items = e.items[:0]
+}
+
+func (e *expectations) add(at Location, lc LineCol, what string) {
+	if e == nil || at.Offset < e.at.Offset {
+		return
+	}
+	if at.Offset > e.at.Offset {
+		e.at = at
+		e.loc = lc
+		e.items = e.items[:0]
+	}
+	// duplicates are removed when formatting
+	e.items = append(e.items, what)
+}
+
+// mark returns the number of items recorded at offset so far.
+func (e *expectations) mark(offset int) int {
+	if e.at.Offset == offset {
+		return len(e.items)
+	}
+	return 0
+}
+
+// replace discards the items recorded at the location after mark n and adds
+// what.
+func (e *expectations) replace(at Location, lc LineCol, n int, what string) {
+	if e.at.Offset == at.Offset {
+		e.items = e.items[:n]
+	}
+	e.add(at, lc, what)
+}
+
+func (e *expectations) String() string {
+	items := make([]string, 0, len(e.items))
+	seen := map[string]bool{}
+	for _, s := range e.items {
+		if !seen[s] {
+			seen[s] = true
+			items = append(items, s)
+		}
+	}
+	switch n := len(items); n {
+	case 0:
+		return ""
+	case 1:
+		return items[0]
+	default:
+		return strings.Join(items[:n-1], ", ") + " or " + items[n-1]
+	}
+}
+
+// quote_term formats literal content for expectation messages.
+func quote_term(s string) string {
+	q := strconv.Quote(s)
+	return "'" + q[1:len(q)-1] + "'"
 }
@@ -1,8 +1,16 @@
 package parse
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf16"
 )
 
 func TestTokenize(t *testing.T) {
@@ -80,8 +88,8 @@ func TestTokenize(t *testing.T) {
 		{`0x1ffffffff`, "<!ERR:[1:1] overflow hex>"},
 		{`0x0000ff`, "0xFF"},
 		{`0xxyz`, "0<id:xxyz>"},
-		{`;`, "<!ERR:[1:1] unexpected content>"},
-		{`42 0xxxyz;`, "42 0<id:xxxyz><!ERR:[1:10] unexpected content>"},
+		{`;`, "<!ERR:[1:1] expected whitespace, ident, string, hex, decimal, single-line comment, multi-line comment or punct>"},
+		{`42 0xxxyz;`, "42 0<id:xxxyz><!ERR:[1:10] expected whitespace, ident, string, hex, decimal, single-line comment, multi-line comment or punct>"},
 		{`42 0xff`, "42 0xFF"},
 		{"''", "<str:>"},
 		{"'abc'", "<str:abc>"},
@@ -166,3 +174,780 @@ func TestTokenize(t *testing.T) {
 		})
 	}
 }
+
+func TestTokenizeExpected(t *testing.T) {
+	bb := []*Binding[string]{
+		Bind("ws", "", Skip(OneOrMore(' '))),
+		Bind("paren", "", AnyOf("(", ")")),
+		Bind("comma", "", ','),
+		Bind("arrow", "", "=>"),
+		Bind("id", "identifier", OneOrMore(func(c rune) bool { return 'a' <= c && c <= 'z' })),
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"(a, b)", ""},
+		{"(a; b)", "[1:3] expected ' ', '(', ')', ',', '=>' or identifier"},
+		{"a =", "[1:3] expected ' ', '(', ')', ',', '=>' or identifier"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			got := ""
+			err := Tokenize([]byte(tt.src), bb, func(string, *Context, LineCol) {})
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("Tokenize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenizeLabels(t *testing.T) {
+	ws := func(c rune) bool { return c == ' ' }
+	id := func(c rune) bool { return 'a' <= c && c <= 'z' }
+	bb := []*Binding[string]{
+		Bind("ws", "whitespace", Skip(OneOrMore(ws))),
+		Bind("str", "string", Labeled("string literal", "did you forget a closing quote?",
+			Between('"', '"', ZeroOrMore(func(c rune) bool { return c != '"' && c != '\n' })))),
+		Bind("let", "let statement", "let", Skip(OneOrMore(ws)), Cut("variable name", OneOrMore(id))),
+		Bind("num", "", Labeled("number", "", OneOrMore(func(c rune) bool { return '0' <= c && c <= '9' }))),
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`let x "abc" 42`, ""},
+		{`"abc`, "[1:1] unterminated string literal (did you forget a closing quote?)"},
+		{`let  42`, "[1:6] expected variable name"},
+		{`;`, "[1:1] expected whitespace, string, let statement or number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			got := ""
+			err := Tokenize([]byte(tt.src), bb, func(string, *Context, LineCol) {})
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("Tokenize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenizeInvalidUTF8(t *testing.T) {
+	bb := []*Binding[string]{
+		Bind("nl", "newline", '\n'),
+		Bind("text", "text", OneOrMore(func(c rune) bool { return c != '\n' })),
+	}
+	src := []byte("ok\nab\xe9\xff!")
+	tests := []struct {
+		policy InvalidPolicy
+		want   string
+	}{
+		{InvalidReplace, "<ok>\n<ab��!>"},
+		{InvalidLatin1, "<ok>\n<abéÿ!>"},
+		{InvalidError, "<ok>\n<!ERR:[2:3] invalid utf-8 sequence>"},
+	}
+	for _, tt := range tests {
+		got := ""
+		err := TokenizeWith(src, bb, func(k string, c *Context, _ LineCol) {
+			if k == "nl" {
+				got += "\n"
+			} else {
+				got += "<" + c.String() + ">"
+			}
+		}, &Options{Invalid: tt.policy})
+		if err != nil {
+			got += fmt.Sprintf("<!ERR:%s>", err)
+		}
+		if got != tt.want {
+			t.Errorf("policy %d: Tokenize() = %q, want %q", tt.policy, got, tt.want)
+		}
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("InvalidPanic did not panic")
+		}
+	}()
+	TokenizeWith(src, bb, func(string, *Context, LineCol) {}, &Options{Invalid: InvalidPanic})
+}
+
+func TestInvalidReads(t *testing.T) {
+	any_rune := func(rune) bool { return true }
+	reads := []struct {
+		name string
+		read func(Source) any
+	}{
+		{"Leap", func(src Source) any { return src.Leap("ab\u00e9") }},
+		{"Leap other", func(src Source) any { return src.Leap("abc") }},
+		{"Skip", func(src Source) any { return string(src.Skip("ab", any_rune)) }},
+		{"Fetch", func(src Source) any { src.Leap("ab"); return string(src.Fetch(nil)) }},
+		{"Hop", func(src Source) any { src.Leap("ab"); return src.Hop('x') }},
+	}
+	tests := []struct {
+		policy InvalidPolicy
+		want   []string
+	}{
+		{InvalidReplace, []string{"false @0", "false @0", "\ufffd @3", "\ufffd @3", "false @2"}},
+		{InvalidLatin1, []string{"true @3", "false @0", "\u00e9 @3", "\u00e9 @3", "false @2"}},
+		{InvalidError, []string{"false [1:3] invalid utf-8 sequence", "false [1:3] invalid utf-8 sequence",
+			"\ufffd [1:3] invalid utf-8 sequence", "\ufffd [1:3] invalid utf-8 sequence", "false [1:3] invalid utf-8 sequence"}},
+	}
+	for _, tt := range tests {
+		for i, rd := range reads {
+			src := StaticWith([]byte("ab\xe9x"), nil, &Options{Invalid: tt.policy})
+			got := fmt.Sprint(rd.read(src))
+			if err := src.Err(); err != nil {
+				got += " " + err.Error()
+			} else {
+				got += fmt.Sprintf(" @%d", src.Location().Offset)
+			}
+			if got != tt.want[i] {
+				t.Errorf("policy %d: %s = %q, want %q", tt.policy, rd.name, got, tt.want[i])
+			}
+		}
+	}
+	for _, rd := range reads {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("InvalidPanic: %s did not panic", rd.name)
+				}
+			}()
+			rd.read(StaticWith([]byte("ab\xe9x"), nil, &Options{Invalid: InvalidPanic}))
+		}()
+	}
+}
+
+func TestRewinder(t *testing.T) {
+	// a source of another type that can be rewound, and one that cannot
+	type rewinder struct {
+		Source
+		Rewinder
+	}
+	rewinding := func(src Source) Source { return rewinder{src, src.(Rewinder)} }
+	plain := func(src Source) Source { return struct{ Source }{src} }
+	tests := []struct {
+		term  TermFunc
+		input string
+		plain string
+	}{
+		{IPv6, "cafe x", `invalid "cafe" @4`},
+		{IPv6, "::1 x", `ok "::1" ::1 @3`},
+		{CIDR, "10.0 x", `invalid "10.0" @4`},
+		{URI(), "word x", `invalid "word" @4`},
+		{URI(), "http://x.com.. x", `ok "http://x.com.." http://x.com.. @14`},
+		{VersionConstraint, "1.2.3, x", `ok "1.2.3" =1.2.3 @7`},
+	}
+	for _, tt := range tests {
+		want := run_term(tt.term, tt.input)
+		if got := run_term_with(tt.term, tt.input, rewinding); got != want {
+			t.Errorf("%q: rewinder %s, want %s", tt.input, got, want)
+		}
+		if got := run_term_with(tt.term, tt.input, plain); got != tt.plain {
+			t.Errorf("%q: other source %s, want %s", tt.input, got, tt.plain)
+		}
+	}
+}
+
+func TestTokenizeEncodings(t *testing.T) {
+	text := "ab => 😀\nx"
+	encode := func(enc Encoding, bom bool) []byte {
+		var out []byte
+		put := func(v rune, n int, le bool) {
+			for i := 0; i < n; i++ {
+				shift := 8 * i
+				if !le {
+					shift = 8 * (n - 1 - i)
+				}
+				out = append(out, byte(v>>shift))
+			}
+		}
+		rr := []rune(text)
+		if bom {
+			rr = append([]rune{0xFEFF}, rr...)
+		}
+		for _, r := range rr {
+			switch enc {
+			case EncodingUTF16LE, EncodingUTF16BE:
+				for _, u := range utf16.Encode([]rune{r}) {
+					put(rune(u), 2, enc == EncodingUTF16LE)
+				}
+			case EncodingUTF32LE, EncodingUTF32BE:
+				put(r, 4, enc == EncodingUTF32LE)
+			default:
+				out = append(out, string(r)...)
+			}
+		}
+		return out
+	}
+
+	bb := []*Binding[string]{
+		Bind("ws", "whitespace", Skip(OneOrMore(func(c rune) bool { return c <= ' ' }))),
+		Bind("arrow", "arrow", "=>"),
+		Bind("word", "word", OneOrMore(func(c rune) bool { return c > ' ' })),
+	}
+	want := "<word:ab@1:1><arrow:=>@1:4><word:😀@1:7><word:x@2:1>"
+
+	for _, enc := range []Encoding{EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE, EncodingUTF32LE, EncodingUTF32BE} {
+		for _, bom := range []bool{false, true} {
+			opts := &Options{Encoding: enc}
+			if bom {
+				opts.Encoding = EncodingAuto
+			}
+			got := ""
+			err := TokenizeWith(encode(enc, bom), bb, func(k string, c *Context, lc LineCol) {
+				if k != "ws" {
+					got += fmt.Sprintf("<%s:%s@%s>", k, c.String(), &lc)
+				}
+			}, opts)
+			if err != nil {
+				got += fmt.Sprintf("<!ERR:%s>", err)
+			}
+			if got != want {
+				t.Errorf("%s (bom: %v): Tokenize() = %s, want %s", enc, bom, got, want)
+			}
+		}
+	}
+
+	// offsets refer to the original bytes
+	src := StaticWith(encode(EncodingUTF16LE, true), nil, &Options{Encoding: EncodingAuto})
+	if src.Offset() != 2 {
+		t.Errorf("offset after BOM = %d, want 2", src.Offset())
+	}
+	src.Leap("ab =>")
+	src.Hop(' ')
+	src.Fetch(nil)
+	if src.Offset() != 2+2*7+2 {
+		t.Errorf("offset after surrogate pair = %d, want %d", src.Offset(), 2+2*7+2)
+	}
+}
+
+func TestTokenizeColumns(t *testing.T) {
+	bb := []*Binding[string]{
+		Bind("ws", "whitespace", Skip(OneOrMore(func(c rune) bool { return c <= ' ' }))),
+		Bind("word", "word", OneOrMore(func(c rune) bool { return c > ' ' })),
+	}
+	src := []byte("\tx 名y 😀z w\n\tv")
+	tests := []struct {
+		mode ColumnMode
+		want string
+	}{
+		{ColumnCodepoints, "x@1:2 名y@1:4 😀z@1:7 w@1:10 v@2:2 "},
+		{ColumnBytes, "x@1:2 名y@1:4 😀z@1:9 w@1:15 v@2:2 "},
+		{ColumnUTF16, "x@1:2 名y@1:4 😀z@1:7 w@1:11 v@2:2 "},
+		{ColumnVisual, "x@1:9 名y@1:11 😀z@1:15 w@1:19 v@2:9 "},
+	}
+	for _, tt := range tests {
+		got := ""
+		err := TokenizeWith(src, bb, func(k string, c *Context, lc LineCol) {
+			if k == "word" {
+				got += fmt.Sprintf("%s@%s ", c.String(), &lc)
+			}
+		}, &Options{Columns: tt.mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("mode %d: Tokenize() = %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestTokenizeVisualClusters(t *testing.T) {
+	bb := []*Binding[string]{
+		Bind("ws", "whitespace", Skip(OneOrMore(func(c rune) bool { return c <= ' ' }))),
+		Bind("word", "word", OneOrMore(func(c rune) bool { return c > ' ' })),
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"e\u0301 x", "1:3"},
+		{"\U0001F469\u200D\U0001F4BB x", "1:4"},       // woman technologist
+		{"\U0001F44D\U0001F3FD x", "1:4"},             // thumbs up, medium skin tone
+		{"\U0001F1FA\U0001F1F8 x", "1:4"},             // flag
+		{"\U0001F1FA\U0001F1F8\U0001F1EC x", "1:6"},   // flag and a lone indicator
+		{"\U0001F3F3\uFE0F\u200D\U0001F308 x", "1:4"}, // rainbow flag
+		{"\U0001F468\u200D\u200D\U0001F467 x", "1:6"}, // doubled joiner
+		{"a\u200D\U0001F467 x", "1:5"},                // not an emoji sequence
+		{"\U0001F3FD x", "1:4"},                       // lone modifier
+	}
+	for _, tt := range tests {
+		got := ""
+		err := TokenizeWith([]byte(tt.src), bb, func(k string, c *Context, lc LineCol) {
+			if k == "word" && c.String() == "x" {
+				got = lc.String()
+			}
+		}, &Options{Columns: ColumnVisual})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: x at %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestTokenizeLineBreaks(t *testing.T) {
+	src := []byte("a\rb\r\nc\u2028d\ne")
+	word := OneOrMore(func(c rune) bool { return c >= 'a' && c <= 'z' })
+	tests := []struct {
+		opts     Options
+		nl       any
+		want     string
+		want_nls string
+	}{
+		{Options{}, LineEnd(BreaksUnicode), "a@1:1 b@1:3 c@2:1 d@2:3 e@3:1 ", `"\r" "\r\n" "\u2028" "\n" `},
+		{Options{LineBreaks: BreaksUnicode}, LineEnd(BreaksUnicode), "a@1:1 b@2:1 c@3:1 d@4:1 e@5:1 ", `"\r" "\r\n" "\u2028" "\n" `},
+		{Options{NormalizeNewlines: true}, FirstOf('\n', '\u2028'), "a@1:1 b@2:1 c@3:1 d@3:3 e@4:1 ", `"\n" "\n" "\u2028" "\n" `},
+	}
+	for i, tt := range tests {
+		bb := []*Binding[string]{
+			Bind("nl", "newline", tt.nl),
+			Bind("word", "word", word),
+		}
+		got, nls := "", ""
+		err := TokenizeWith(src, bb, func(k string, c *Context, lc LineCol) {
+			if k == "word" {
+				got += fmt.Sprintf("%s@%s ", c.String(), &lc)
+			} else {
+				nls += fmt.Sprintf("%q ", c.String())
+			}
+		}, &tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want || nls != tt.want_nls {
+			t.Errorf("%d: Tokenize() = %q %s, want %q %s", i, got, nls, tt.want, tt.want_nls)
+		}
+	}
+}
+
+func TestTokenizeSpans(t *testing.T) {
+	bb := []*Binding[string]{
+		Bind("ws", "whitespace", Skip(OneOrMore(func(c rune) bool { return c <= ' ' }))),
+		Bind("word", "word", OneOrMore(func(c rune) bool { return c > ' ' })),
+	}
+	src := []byte("ab цd\r\n  xyz")
+	li := NewLineIndex(src)
+	got := ""
+	err := TokenizeWith(src, bb, func(k string, c *Context, lc LineCol) {
+		if k != "word" {
+			return
+		}
+		got += fmt.Sprintf("%s@%s ", c.Span.Text(src), &c.Span)
+		if c.Span.Start != li.Location(c.Span.Start.Offset) {
+			t.Errorf("Span.Start = %+v, want %+v", c.Span.Start, li.Location(c.Span.Start.Offset))
+		}
+		if at := c.Span.Start.LineCol(src); at != lc {
+			t.Errorf("Span.Start.LineCol() = %s, want %s", &at, &lc)
+		}
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ab@1:1-1:3 цd@1:4-1:7 xyz@2:3-2:6 "; got != want {
+		t.Errorf("Tokenize() = %q, want %q", got, want)
+	}
+
+	var e *ErrAtLineCol
+	err = Tokenize([]byte("ab 'cd"), []*Binding[string]{
+		Bind("ws", "whitespace", Skip(OneOrMore(func(c rune) bool { return c <= ' ' }))),
+		Bind("word", "word", OneOrMore(func(c rune) bool { return c > ' ' && c != '\'' })),
+		Bind("str", "string", Between('\'', '\'')),
+	}, func(k string, c *Context, lc LineCol) {})
+	if !errors.As(err, &e) {
+		t.Fatalf("Tokenize() = %v, want ErrAtLineCol", err)
+	}
+	if got, want := e.Span.String(), "1:4-1:7"; got != want {
+		t.Errorf("error span = %s, want %s", got, want)
+	}
+}
+
+func TestTokenizeZeroCopy(t *testing.T) {
+	word := func(c rune) bool { return c > ' ' && c != '"' && c != '\\' }
+	bb := []*Binding[string]{
+		Bind("ws", "whitespace", Skip(OneOrMore(func(c rune) bool { return c <= ' ' }))),
+		Bind("word", "word", OneOrMore(word)),
+		Bind("str", "string", Between('"', '"', ZeroOrMore(FirstOf(
+			Escaped('\\', map[rune]any{'n': '\n', '\\': '\\', '"': '"'}),
+			word, ' ')))),
+	}
+	src := []byte(`abc "x y" "a\nb" "\\z" "q\"" цd`)
+	tests := []struct {
+		text  string
+		alias bool
+	}{
+		{"abc", true}, {"x y", true}, {"a\nb", false}, {`\z`, true}, {`q"`, false}, {"цd", true},
+	}
+	i := 0
+	kept := []string{}
+	err := Tokenize(src, bb, func(k string, c *Context, lc LineCol) {
+		if k == "ws" {
+			return
+		}
+		kept = append(kept, c.String())
+		if i >= len(tests) {
+			t.Fatalf("unexpected token %q", c.String())
+		}
+		tt := tests[i]
+		i++
+		b := c.Bytes()
+		if c.String() != tt.text || string(b) != tt.text || c.Len() != len(tt.text) {
+			t.Errorf("token %d = %q, want %q", i, c.String(), tt.text)
+		}
+		at := bytes.Index(src, b)
+		in := at >= 0 && &b[0] == &src[at]
+		if in != tt.alias {
+			t.Errorf("token %q: aliasing input = %v, want %v", tt.text, in, tt.alias)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != len(tests) {
+		t.Errorf("got %d tokens, want %d", i, len(tests))
+	}
+
+	// the strings are copies, unaffected by changes of the input
+	copy(src, "XXXXXXXXXX")
+	for i, s := range kept {
+		if s != tests[i].text {
+			t.Errorf("kept token %d = %q after the input changed, want %q", i, s, tests[i].text)
+		}
+	}
+}
+
+func TestTokenizeRegexp(t *testing.T) {
+	bb := []*Binding[string]{
+		Bind("ws", "whitespace", Skip(OneOrMore(func(c rune) bool { return c <= ' ' }))),
+		Bind("assign", "assignment", Regexp(`([a-zа-я]+)\s*=\s*([0-9]+)(px)?`)),
+		Bind("op", "operator", Regexp(`<|<=|<<=`)),
+	}
+	tests := []struct {
+		src  string
+		opts Options
+		want string
+	}{
+		{"x=1 <<= width = 20px", Options{}, `x=1[x 1 ] <<=[] width = 20px[width 20 px] `},
+		{"шир=2\n<", Options{}, `шир=2[шир 2 ] <[] `},
+		{"a=1 b", Options{}, `a=1[a 1 ] error: [1:5] expected whitespace, assignment or operator`},
+		{"\xff\xfex\x00=\x001\x00", Options{Encoding: EncodingAuto}, `x=1[x 1 ] `},
+	}
+	for _, tt := range tests {
+		got := ""
+		err := TokenizeWith([]byte(tt.src), bb, func(k string, c *Context, lc LineCol) {
+			if k != "ws" {
+				got += fmt.Sprintf("%s%v ", c.String(), c.Values)
+			}
+		}, &tt.opts)
+		if err != nil {
+			got += "error: " + err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("Tokenize(%q) = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+
+	// content beyond regexp_window is matched through a reader
+	long := strings.Repeat("ab = 12 ", regexp_window/4)
+	n := 0
+	err := Tokenize([]byte(long), bb, func(k string, c *Context, lc LineCol) {
+		if k == "assign" {
+			if c.String() != "ab = 12" || fmt.Sprint(c.Values) != "[ab 12 ]" {
+				t.Errorf("long input: token %q %v", c.String(), c.Values)
+			}
+			n++
+		}
+	})
+	if err != nil || n != regexp_window/4 {
+		t.Errorf("long input: %d tokens, %v", n, err)
+	}
+
+	// other sources cannot be looked ahead
+	src := struct{ Source }{Static([]byte("a=1"), nil)}
+	if ec := bb[1].c(src, &Context{}); ec != ErrCodeUnmatched || src.Peek() != 'a' {
+		t.Errorf("wrapped source: %v", ec)
+	}
+}
+
+func TestTokenizeParallel(t *testing.T) {
+	log := bench_log()[:20000]
+	bad := append(append(append([]byte{}, log[:10000]...), "%%\n"...), log[10000:]...)
+	utf16le := []byte{0xff, 0xfe}
+	for _, c := range utf16.Encode([]rune(string(log[:2000]))) {
+		utf16le = append(utf16le, byte(c), byte(c>>8))
+	}
+	tests := []struct {
+		name string
+		src  []byte
+		opts ParallelOptions
+	}{
+		{"log", log, ParallelOptions{}},
+		{"error", bad, ParallelOptions{}},
+		{"resync", log, ParallelOptions{Resync: Codepoint('2')}},
+		{"crlf", bytes.ReplaceAll(log, []byte("\n"), []byte("\r\n")),
+			ParallelOptions{Options: Options{Columns: ColumnUTF16, NormalizeNewlines: true, LineBreaks: BreakCR | BreakLF}}},
+		{"utf16", utf16le, ParallelOptions{Options: Options{Encoding: EncodingAuto}}},
+		{"max tokens", log, ParallelOptions{Options: Options{Limits: Limits{MaxTokens: 500}}}},
+		{"max token len", log, ParallelOptions{Options: Options{Limits: Limits{MaxTokenLen: 12}}}},
+		{"memo", log, ParallelOptions{Options: Options{Memo: &Memo{}}}},
+	}
+	bb := log_bindings()
+	for _, tt := range tests {
+		run := func(tokenize func(on_token func(k string, c *Context, lc LineCol)) error) string {
+			b := strings.Builder{}
+			err := tokenize(func(k string, c *Context, lc LineCol) {
+				fmt.Fprintf(&b, "%s:%q%v@%s %s\n", k, c.String(), c.Values, &lc, &c.Span)
+			})
+			fmt.Fprintf(&b, "error: %v", err)
+			return b.String()
+		}
+		want := run(func(on_token func(k string, c *Context, lc LineCol)) error {
+			return TokenizeWith(tt.src, bb, on_token, &tt.opts.Options)
+		})
+		for _, workers := range []int{1, 3} {
+			opts := tt.opts
+			opts.Workers = workers
+			opts.ChunkSize = 700
+			got := run(func(on_token func(k string, c *Context, lc LineCol)) error {
+				return TokenizeParallel(context.Background(), tt.src, bb, on_token, &opts)
+			})
+			if got != want {
+				t.Errorf("%s: TokenizeParallel(workers = %d) differs from TokenizeWith", tt.name, workers)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n := 0
+	err := TokenizeParallel(ctx, log, bb, func(k string, c *Context, lc LineCol) {
+		if n++; n == 10 {
+			cancel()
+		}
+	}, &ParallelOptions{ChunkSize: 700})
+	if !errors.Is(err, context.Canceled) || n != 10 {
+		t.Errorf("canceled TokenizeParallel() = %v after %d tokens, want %v after 10", err, n, context.Canceled)
+	}
+}
+
+func TestTokenizeLimits(t *testing.T) {
+	list := Recursive(func(list TermFunc) TermFunc {
+		return Sequence('(', ZeroOrMore(FirstOf(list, is_alpha)), ')')
+	})
+	bb := append([]*Binding[string]{Bind("list", "list", list)}, c_bindings()...)
+	compiled := make([]*Binding[string], len(bb))
+	for i, b := range bb {
+		compiled[i] = Bind(b.k, b.descr, Compile(b.c))
+	}
+	long := "x /*" + strings.Repeat("long comment ", 10000)
+	tests := []struct {
+		src    string
+		limits Limits
+		want   error
+		msg    string
+	}{
+		{long + "*/", Limits{}, nil, ""},
+		{long + "*/", Limits{MaxTokenLen: 1000}, ErrTokenLength, "[1:1004] exceeded token length limit"},
+		{long, Limits{MaxTokenLen: 1000}, ErrTokenLength, "[1:1004] exceeded token length limit"},
+		// the limit is reached on the last byte and past the end of input
+		{"/*abcdef", Limits{MaxTokenLen: 7}, ErrTokenLength, "[1:9] exceeded token length limit"},
+		{"/*abcdef", Limits{MaxTokenLen: 6}, ErrTokenLength, "[1:8] exceeded token length limit"},
+		{"/*abcde\u00e9", Limits{MaxTokenLen: 8}, ErrTokenLength, "[1:9] exceeded token length limit"},
+		{"/*abcd*/", Limits{MaxTokenLen: 8}, nil, ""},
+		{"a b c d", Limits{MaxTokens: 7}, nil, ""},
+		{"a b c d", Limits{MaxTokens: 3}, ErrTokenCount, "[1:4] exceeded token count limit"},
+		{"((a)(b(c)))", Limits{MaxDepth: 4}, nil, ""},
+		{"((a)(b(c)))", Limits{MaxDepth: 3}, ErrDepth, "[1:8] exceeded nesting depth limit"},
+	}
+	for _, tt := range tests {
+		for _, bb := range [][]*Binding[string]{bb, compiled} {
+			err := TokenizeWith([]byte(tt.src), bb, func(k string, c *Context, lc LineCol) {}, &Options{Limits: tt.limits})
+			if tt.want == nil && err != nil || tt.want != nil && (!errors.Is(err, tt.want) || err.Error() != tt.msg) {
+				t.Errorf("TokenizeWith(%.20q, %+v) = %v, want %s", tt.src, tt.limits, err, tt.msg)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := TokenizeContext(ctx, []byte("a b c"), bb, func(k string, c *Context, lc LineCol) {
+		t.Errorf("unexpected token %q", c.String())
+	}, nil)
+	if err != context.Canceled {
+		t.Errorf("TokenizeContext() = %v, want %v", err, context.Canceled)
+	}
+
+	// canceled while matching an unterminated comment
+	ctx, cancel = context.WithCancel(context.Background())
+	canceling := func(src Source, c *Context) ErrCode {
+		cancel()
+		return ErrCodeNone
+	}
+	for _, term := range []TermFunc{Between("/*", "*/"), Compile(Between("/*", "*/"))} {
+		err = TokenizeContext(ctx, []byte(long), []*Binding[string]{
+			Bind("ws", "whitespace", Skip(OneOrMore(is_ws))),
+			Bind("id", "ident", OneOrMore(is_alpha)),
+			Bind("mlc", "comment", canceling, term),
+		}, func(k string, c *Context, lc LineCol) {}, nil)
+		if err != context.Canceled {
+			t.Errorf("TokenizeContext() = %v, want %v", err, context.Canceled)
+		}
+	}
+
+	// timed out while trying alternatives without consuming content, each
+	// level tries the level below twice at the same offset
+	chain := Literal("x")
+	for i := 0; i < 40; i++ {
+		chain = FirstOf(Sequence(chain, "a"), Sequence(chain, "b"))
+	}
+	for _, term := range []TermFunc{chain, Recursive(func(TermFunc) TermFunc { return chain })} {
+		ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		err = TokenizeContext(ctx, []byte("y"), []*Binding[string]{Bind("chain", "", term)},
+			func(k string, c *Context, lc LineCol) {}, nil)
+		cancel()
+		if err != context.DeadlineExceeded || time.Since(start) > 5*time.Second {
+			t.Errorf("TokenizeContext() = %v after %v, want %v", err, time.Since(start), context.DeadlineExceeded)
+		}
+	}
+}
+
+func TestTokenizeMemo(t *testing.T) {
+	// each level tries the level below twice at the same offset
+	calls := 0
+	x := Literal("x")
+	chain := func(src Source, c *Context) ErrCode {
+		calls++
+		return x(src, c)
+	}
+	for i := 0; i < 12; i++ {
+		m := Memoize(chain)
+		chain = FirstOf(Sequence(m, "a"), Sequence(m, "b"))
+	}
+	bb := []*Binding[string]{
+		Bind("chain", "", chain),
+		Bind("ws", "whitespace", Skip(OneOrMore(is_ws))),
+		Bind("id", "ident", OneOrMore(is_alpha)),
+	}
+	tokenize := func(src string, opts *Options) (string, error) {
+		calls = 0
+		b := strings.Builder{}
+		err := TokenizeWith([]byte(src), bb, func(k string, c *Context, lc LineCol) {
+			fmt.Fprintf(&b, "%s:%q ", k, c.String())
+		}, opts)
+		return b.String(), err
+	}
+	tests := []struct {
+		src   string
+		calls int
+		memo  MemoStats
+	}{
+		{"x y x y", 2 + 5<<12, MemoStats{Hits: 60, Misses: 84}},
+		{"xb @", 1 + 4<<12, MemoStats{Hits: 36, Misses: 48}},
+	}
+	for _, tt := range tests {
+		want, want_err := tokenize(tt.src, nil)
+		if calls != tt.calls {
+			t.Errorf("%q: %d calls without memo, want %d", tt.src, calls, tt.calls)
+		}
+		memo := &Memo{}
+		got, err := tokenize(tt.src, &Options{Memo: memo})
+		if got != want || fmt.Sprint(err) != fmt.Sprint(want_err) {
+			t.Errorf("%q: memoized tokens %s, %v, want %s, %v", tt.src, got, err, want, want_err)
+		}
+		if s := memo.Stats(); s.Hits != tt.memo.Hits || s.Misses != tt.memo.Misses {
+			t.Errorf("%q: memo stats %+v, want %+v", tt.src, s, tt.memo)
+		}
+	}
+
+	// replayed outcomes
+	memo := &Memo{MaxEntries: 2}
+	num := Memoize(Uint[uint8]("", 10, 255), EOL)
+	src := StaticWith([]byte("12\n34\n56"), nil, &Options{Memo: memo, LineIndex: &LineIndex{}})
+	lines := []int{2, 3, 3}
+	for i, want := range []string{"12\n", "34\n", "56"} {
+		st := src.state()
+		if num(src, &Context{muted: 1}) != ErrCodeNone {
+			t.Fatalf("num() at %d did not match", st.pos)
+		}
+		end := src.Location()
+		for _, muted := range []int{1, 0} {
+			src.restore(st)
+			c := Context{muted: muted}
+			c.Attach(src)
+			if ec := num(src, &c); ec != ErrCodeNone || src.Location() != end ||
+				muted == 0 && c.String() != want || len(c.Values) != 1 || c.Values[0] != uint8(12+22*i) {
+				t.Errorf("num() at %d = %v, %q, %v at %+v, want %q at %+v", st.pos, ec, c.String(), c.Values, src.Location(), want, end)
+			}
+		}
+		if got := src.lines.LineCount(); got != lines[i] {
+			t.Errorf("line count %d after %q, want %d", got, want, lines[i])
+		}
+	}
+	if s := memo.Stats(); s != (MemoStats{Hits: 3, Misses: 6, Evictions: 2, Entries: 1}) {
+		t.Errorf("memo stats %+v", s)
+	}
+}
+
+func TestRetokenize(t *testing.T) {
+	matched := 0 // token starts
+	bb := c_bindings()
+	ws := bb[0].c
+	bb[0] = Bind("ws", "whitespace", func(src Source, c *Context) ErrCode {
+		matched++
+		return ws(src, c)
+	})
+	fragments := []string{"x", "1", " ", "\n", "\r\n", "\r", "\t", "/*", "*/", "//", "'", "'a'", "0x", "@", "é"}
+	for _, opts := range []*Options{
+		nil,
+		{Columns: ColumnVisual, LineBreaks: BreakCR | BreakLF},
+		{Columns: ColumnUTF16, NormalizeNewlines: true},
+	} {
+		rnd := rand.New(rand.NewSource(1))
+		buf := bench_c()[:3000]
+		tokens, err := TokenizeAll(buf, bb, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 300; i++ {
+			e := Edit{Offset: rnd.Intn(len(buf))}
+			if rnd.Intn(3) == 0 {
+				e.Deleted = rnd.Intn(len(buf)-e.Offset) % 20
+			} else {
+				e.Inserted = fragments[rnd.Intn(len(fragments))]
+			}
+			if i%50 == 0 {
+				// restore the content that the errors are about
+				e = Edit{Deleted: len(buf), Inserted: string(bench_c()[:3000])}
+			}
+			buf = e.Apply(buf)
+			matched = 0
+			tokens, err = Retokenize(buf, tokens, e, bb, opts)
+			n := matched
+			want, want_err := TokenizeAll(buf, bb, opts)
+			if fmt.Sprint(err) != fmt.Sprint(want_err) {
+				t.Fatalf("%+v: Retokenize() error = %v, want %v", e, err, want_err)
+			}
+			for j := range want {
+				if j >= len(tokens) || !reflect.DeepEqual(tokens[j], want[j]) {
+					t.Fatalf("%+v: Retokenize() token %d = %v, want %v", e, j, tokens[j:], want[j])
+				}
+			}
+			if len(tokens) != len(want) {
+				t.Fatalf("%+v: Retokenize() = %d tokens, want %d", e, len(tokens), len(want))
+			}
+			// with visual columns, a token has to be at the same column too
+			visual := opts != nil && opts.Columns == ColumnVisual
+			if !visual && e.Deleted+len(e.Inserted) < 20 && err == nil && n > 12 && !strings.Contains(e.Inserted, "/*") {
+				t.Errorf("%+v: %d tokens matched again", e, n)
+			}
+		}
+	}
+}
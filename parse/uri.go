@@ -0,0 +1,282 @@
+package parse
+
+import (
+	"net/netip"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// URI creates a matcher for URIs as defined by RFC 3986:
+//
+//	scheme ":" ["//" authority] path ["?" query] ["#" fragment]
+//
+// The raw text of the URI is captured and the corresponding *url.URL value is
+// appended to ctx.Values. Percent-encoded triplets are validated but kept
+// encoded in the captured text.
+//
+// When schemes are specified (without the trailing colon), only URIs that
+// start with one of them are matched. Such matchers do not consume anything
+// unless the scheme is followed by a colon, which makes them suitable for
+// recognizing URIs in free text. Without schemes, any RFC 3986 scheme is
+// accepted; a word that is not followed by a colon is not consumed, see
+// Rewinder.
+//
+// To make URIs in free text more natural, a trailing punctuation character
+// such as `.` or `,` is not consumed unless it is followed by more URI
+// content.
+//
+// Returned values are:
+//
+//   - `ErrCodeUnmatched` if src does not start with a scheme followed by a
+//     colon
+//   - `ErrCodeInvalid` if the URI is malformed
+//   - `ErrCodeNone` if src contains a valid URI
+func URI(schemes ...string) TermFunc {
+	prefixes := make([]string, 0, len(schemes))
+	for _, s := range schemes {
+		if !valid_scheme(s) {
+			panic("invalid uri scheme")
+		}
+		prefixes = append(prefixes, s+":")
+	}
+	// longest first
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(prefixes[i]) > len(prefixes[j])
+	})
+
+	return func(src Source, ctx *Context) ErrCode {
+		raw := strings.Builder{}
+		if len(prefixes) > 0 {
+			found := false
+			for _, p := range prefixes {
+				if src.Leap(p) {
+					raw.WriteString(p)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return ErrCodeUnmatched
+			}
+		} else {
+			rw, _ := src.(Rewinder)
+			var mark any
+			if rw != nil {
+				mark = rw.Mark()
+			}
+			c := src.Fetch(is_alpha)
+			if c == Unmatched {
+				return ErrCodeUnmatched
+			}
+			raw.WriteRune(c)
+			for c = src.Fetch(is_scheme_char); c != Unmatched; c = src.Fetch(is_scheme_char) {
+				raw.WriteRune(c)
+			}
+			if !src.Hop(':') {
+				if rw != nil {
+					rw.Rewind(mark)
+					return ErrCodeUnmatched
+				}
+				if ctx != nil {
+					ctx.WriteString(raw.String())
+				}
+				return ErrCodeInvalid
+			}
+			raw.WriteByte(':')
+		}
+
+		ec := ErrCodeNone
+		if src.Leap("//") {
+			raw.WriteString("//")
+			authority := strings.Builder{}
+			ec = scan_uri_part(src, &authority, is_authority_char)
+			raw.WriteString(authority.String())
+			if ec == ErrCodeNone && !valid_authority(authority.String()) {
+				ec = ErrCodeInvalid
+			}
+		}
+		if ec == ErrCodeNone {
+			ec = scan_uri_part(src, &raw, is_path_char)
+		}
+		if ec == ErrCodeNone && src.Hop('?') {
+			raw.WriteByte('?')
+			ec = scan_uri_part(src, &raw, is_query_char)
+		}
+		if ec == ErrCodeNone && src.Hop('#') {
+			raw.WriteByte('#')
+			ec = scan_uri_part(src, &raw, is_query_char)
+		}
+
+		if ctx != nil {
+			ctx.WriteString(raw.String())
+		}
+		if ec != ErrCodeNone {
+			return ec
+		}
+		u, err := url.Parse(raw.String())
+		if err != nil {
+			return ErrCodeInvalid
+		}
+		if ctx != nil {
+			ctx.Values = append(ctx.Values, u)
+		}
+		return ErrCodeNone
+	}
+}
+
+// PercentEncoded is an escaper for the `%XX` triplets used by URIs and HTML
+// form encoding. It reads two hexadecimal digits from src and inserts the
+// corresponding octet into the captured string, similar to HexCodeunit_XX.
+// Use it with Escaped:
+//
+//	Escaped('%', map[rune]any{Unmatched: PercentEncoded})
+//
+// Returned values are:
+//
+//   - `ErrCodeUnmatched` if src does not start with the hex digit
+//   - `ErrCodeIncomplete` if src contains only one hex digit
+//   - `ErrCodeNone` if src contains two hex digits
+func PercentEncoded(src Source, ctx *Context) ErrCode {
+	return HexCodeunit_XX(src, ctx)
+}
+
+// scan_uri_part consumes characters allowed by f along with percent-encoded
+// triplets, writing the raw text into b.
+func scan_uri_part(src Source, b *strings.Builder, f func(rune) bool) ErrCode {
+	for {
+		c := src.Fetch(func(c rune) bool { return f(c) && !is_trailing_punct(c) })
+		if c == Unmatched {
+			c = scan_uri_punct(src, b, f)
+		}
+		switch c {
+		case Unmatched:
+			return ErrCodeNone
+		case '%':
+			b.WriteByte('%')
+			for i := 0; i < 2; i++ {
+				d := src.Fetch(is_hex)
+				if d == Unmatched {
+					return ErrCodeInvalid
+				}
+				b.WriteRune(d)
+			}
+		default:
+			b.WriteRune(c)
+		}
+	}
+}
+
+// scan_uri_punct consumes a punctuation character allowed by f if it is
+// followed by more content, possibly more punctuation, and returns the next
+// codepoint to add to b. If src is not a Rewinder, the punctuation is
+// written to b and consumed along with the codepoint that follows, which may
+// be more punctuation.
+func scan_uri_punct(src Source, b *strings.Builder, f func(rune) bool) rune {
+	more := func(c rune) bool { return c == '%' || f(c) }
+	if rw, _ := src.(Rewinder); rw != nil {
+		mark := rw.Mark()
+		p := src.Fetch(func(c rune) bool { return f(c) && is_trailing_punct(c) })
+		if p == Unmatched {
+			return Unmatched
+		}
+		if c := src.Peek(); c != Unmatched && more(c) {
+			return p
+		}
+		rw.Rewind(mark)
+		return Unmatched
+	}
+	for _, p := range trailing_punct {
+		if f(p) {
+			if c := src.Skip(string(p), more); c != Unmatched {
+				b.WriteRune(p)
+				return c
+			}
+		}
+	}
+	return Unmatched
+}
+
+func valid_scheme(s string) bool {
+	for i, c := range s {
+		if i == 0 && !is_alpha(c) || !is_scheme_char(c) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func valid_authority(s string) bool {
+	if i := strings.LastIndexByte(s, '@'); i >= 0 {
+		if strings.ContainsAny(s[:i], "[]") {
+			return false
+		}
+		s = s[i+1:]
+	}
+	host, port := s, ""
+	if strings.HasPrefix(s, "[") {
+		i := strings.IndexByte(s, ']')
+		if i < 0 {
+			return false
+		}
+		host, port = s[1:i], s[i+1:]
+		if strings.HasPrefix(host, "v") || strings.HasPrefix(host, "V") {
+			// IPvFuture
+			if !strings.Contains(host, ".") {
+				return false
+			}
+		} else if a, err := netip.ParseAddr(host); err != nil || !a.Is6() {
+			return false
+		}
+		if port != "" && port[0] != ':' {
+			return false
+		}
+	} else if i := strings.IndexByte(s, ':'); i >= 0 {
+		host, port = s[:i], s[i:]
+		if strings.ContainsAny(host, "[]") {
+			return false
+		}
+	}
+	if port != "" {
+		for _, c := range port[1:] {
+			if !is_dec(c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+const trailing_punct = ".,;:!?'"
+
+func is_trailing_punct(c rune) bool {
+	return strings.ContainsRune(trailing_punct, c)
+}
+
+func is_alpha(c rune) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z'
+}
+
+func is_scheme_char(c rune) bool {
+	return is_alpha(c) || is_dec(c) || c == '+' || c == '-' || c == '.'
+}
+
+func is_unreserved(c rune) bool {
+	return is_alpha(c) || is_dec(c) || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func is_sub_delim(c rune) bool {
+	return strings.ContainsRune("!$&'()*+,;=", c)
+}
+
+func is_authority_char(c rune) bool {
+	return c == '%' || is_unreserved(c) || is_sub_delim(c) || c == ':' || c == '@' || c == '[' || c == ']'
+}
+
+func is_path_char(c rune) bool {
+	return c == '%' || is_unreserved(c) || is_sub_delim(c) || c == ':' || c == '@' || c == '/'
+}
+
+func is_query_char(c rune) bool {
+	return is_path_char(c) || c == '?'
+}
@@ -0,0 +1,42 @@
+package parse
+
+import "testing"
+
+func TestURI(t *testing.T) {
+	any_uri := URI()
+	web := URI("http", "https")
+	tests := []struct {
+		term  TermFunc
+		input string
+		want  string
+	}{
+		{any_uri, "http://example.com", `ok "http://example.com" http://example.com @18`},
+		{any_uri, "https://user@example.com:8080/a/b?x=1&y=2#top", `ok "https://user@example.com:8080/a/b?x=1&y=2#top" https://user@example.com:8080/a/b?x=1&y=2#top @45`},
+		{any_uri, "mailto:joe@example.com", `ok "mailto:joe@example.com" mailto:joe@example.com @22`},
+		{any_uri, "urn:isbn:0451450523", `ok "urn:isbn:0451450523" urn:isbn:0451450523 @19`},
+		{any_uri, "http://[::1]:80/", `ok "http://[::1]:80/" http://[::1]:80/ @16`},
+		{any_uri, "http://x/a%20b", `ok "http://x/a%20b" http://x/a%20b @14`},
+		{any_uri, "http://x/a/../b", `ok "http://x/a/../b" http://x/a/../b @15`},
+		{any_uri, "http://x.com.", `ok "http://x.com" http://x.com @12`},
+		{any_uri, "http://x.com..", `ok "http://x.com." http://x.com. @13`},
+		{any_uri, "http://x.com/a, b", `ok "http://x.com/a" http://x.com/a @14`},
+		{any_uri, "http://x.com/a.b)", `ok "http://x.com/a.b)" http://x.com/a.b) @17`},
+		{any_uri, "http://x.com/?q=1.", `ok "http://x.com/?q=1" http://x.com/?q=1 @17`},
+		{any_uri, "http://x/a%2", `invalid "http://x/a%2" @12`},
+		{any_uri, "http://[::1/", `invalid "http://[::1" @11`},
+		{any_uri, "http://x:8a/", `invalid "http://x:8a" @11`},
+		{any_uri, "word", `unmatched "" @0`},
+		{any_uri, "word.x y", `unmatched "" @0`},
+		{any_uri, "1http:x", `unmatched "" @0`},
+
+		{web, "https://example.com/", `ok "https://example.com/" https://example.com/ @20`},
+		{web, "http:x", `ok "http:x" http:x @6`},
+		{web, "ftp://example.com/", `unmatched "" @0`},
+		{web, "http", `unmatched "" @0`},
+	}
+	for _, tt := range tests {
+		if got := run_term(tt.term, tt.input); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package parse
+
+import "unicode"
+
+// wide_ranges lists the East Asian Wide (W) and Fullwidth (F) blocks that
+// terminals render with two cells.
+var wide_ranges = [][2]rune{
+	{0x1100, 0x115F},   // Hangul Jamo
+	{0x231A, 0x231B},   // watch, hourglass
+	{0x2329, 0x232A},   // angle brackets
+	{0x23E9, 0x23EC},   // media controls
+	{0x25FD, 0x25FE},   // small squares
+	{0x2614, 0x2615},   // umbrella, hot beverage
+	{0x2E80, 0x303E},   // CJK radicals, punctuation
+	{0x3041, 0x33FF},   // kana, CJK compatibility
+	{0x3400, 0x4DBF},   // CJK extension A
+	{0x4E00, 0x9FFF},   // CJK unified ideographs
+	{0xA000, 0xA4CF},   // Yi
+	{0xA960, 0xA97F},   // Hangul Jamo extended A
+	{0xAC00, 0xD7A3},   // Hangul syllables
+	{0xF900, 0xFAFF},   // CJK compatibility ideographs
+	{0xFE10, 0xFE19},   // vertical forms
+	{0xFE30, 0xFE6F},   // CJK compatibility forms, small forms
+	{0xFF00, 0xFF60},   // fullwidth forms
+	{0xFFE0, 0xFFE6},   // fullwidth signs
+	{0x16FE0, 0x18CFF}, // Tangut, Khitan
+	{0x1B000, 0x1B2FF}, // kana supplement, Nushu
+	{0x1F004, 0x1F004}, // mahjong tile
+	{0x1F0CF, 0x1F0CF}, // playing card
+	{0x1F18E, 0x1F18E}, // negative squared AB
+	{0x1F191, 0x1F19A}, // squared words
+	{0x1F200, 0x1F2FF}, // enclosed ideographic supplement
+	{0x1F300, 0x1F64F}, // pictographs, emoticons
+	{0x1F680, 0x1F6FF}, // transport and map symbols
+	{0x1F7E0, 0x1F7EB}, // colored circles and squares
+	{0x1F90C, 0x1F9FF}, // supplemental symbols and pictographs
+	{0x1FA70, 0x1FAFF}, // symbols and pictographs extended A
+	{0x20000, 0x2FFFD}, // CJK extensions B..F
+	{0x30000, 0x3FFFD}, // CJK extension G
+}
+
+// rune_width returns the number of terminal cells occupied by c: 0 for
+// combining marks and other zero-width codepoints, 2 for East Asian wide and
+// fullwidth codepoints, 1 otherwise.
+func rune_width(c rune) int {
+	switch {
+	case c < 0x300:
+		if c < 0x20 || c >= 0x7f && c < 0xa0 {
+			return 0
+		}
+		return 1
+	case c == 0x200B || c == 0x200C || c == 0x200D || c == 0xFEFF:
+		return 0
+	case unicode.In(c, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case c < wide_ranges[0][0]:
+		return 1
+	}
+	lo, hi := 0, len(wide_ranges)
+	for lo < hi {
+		m := (lo + hi) / 2
+		switch {
+		case c < wide_ranges[m][0]:
+			hi = m
+		case c > wide_ranges[m][1]:
+			lo = m + 1
+		default:
+			return 2
+		}
+	}
+	return 1
+}
+
+// cluster_state tracks the emoji sequences that terminals render as a single
+// glyph, see visual_width.
+type cluster_state uint8
+
+const (
+	cluster_none  = cluster_state(iota)
+	cluster_emoji // after a pictograph, possibly with modifiers
+	cluster_zwj   // after a pictograph followed by a zero width joiner
+	cluster_flag  // after the first regional indicator of a pair
+)
+
+// visual_width returns the number of terminal cells that c adds to the
+// preceding content. Pictographs joined with U+200D, emoji modifiers and the
+// second regional indicator of a flag do not add any.
+func (r *static_impl) visual_width(c rune) int {
+	prev := r.cluster
+	r.cluster = cluster_none
+	switch {
+	case 0x1F1E6 <= c && c <= 0x1F1FF:
+		// regional indicators
+		if prev == cluster_flag {
+			return 0
+		}
+		r.cluster = cluster_flag
+		return 2
+	case 0x1F3FB <= c && c <= 0x1F3FF && prev == cluster_emoji:
+		// emoji modifiers
+		r.cluster = cluster_emoji
+		return 0
+	case c == 0x200D && prev == cluster_emoji:
+		r.cluster = cluster_zwj
+		return 0
+	case is_pictograph(c):
+		r.cluster = cluster_emoji
+		if prev == cluster_zwj {
+			return 0
+		}
+		return rune_width(c)
+	}
+	w := rune_width(c)
+	if w == 0 && c != 0x200D && prev == cluster_emoji {
+		// variation selectors, tags, enclosing keycaps
+		r.cluster = cluster_emoji
+	}
+	return w
+}
+
+// is_pictograph approximates the Extended_Pictographic property of Unicode
+// with the blocks that contain emoji.
+func is_pictograph(c rune) bool {
+	switch {
+	case c < 0x2000:
+		return c == 0xA9 || c == 0xAE
+	case c < 0x2C00:
+		return c == 0x203C || c == 0x2049 || c == 0x2122 || c == 0x2139 ||
+			0x2190 <= c && c <= 0x21FF || 0x2300 <= c && c <= 0x23FF ||
+			0x2460 <= c && c <= 0x27BF || 0x2900 <= c && c <= 0x2BFF
+	}
+	return 0x1F000 <= c && c <= 0x1FAFF
+}